@@ -116,6 +116,8 @@ func ReadIntegerFromStr(typ Type, input string) (interface{}, error) {
 		case 256:
 			return num, nil
 		}
+	}
+	if typ.T == IntTy {
 		switch typ.Size {
 		case 8:
 			return int8(num.Int64()), nil
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config is the optional user configuration, read from ~/.config/jeth/config.json
+type Config struct {
+	// named groups of endpoints, used with --rpc.group
+	Endpoints map[string][]EndpointConfig `json:"endpoints"`
+	// named networks, used with --network
+	Networks map[string]NetworkConfig `json:"networks"`
+}
+
+// NetworkConfig is a chain with the endpoints to reach it and defaults for its commands
+type NetworkConfig struct {
+	Name      string           `json:"-"`
+	Endpoints []EndpointConfig `json:"endpoints"`
+	// the chain id the endpoints have to report before anything is sent that changes state
+	ChainId uint64 `json:"chainId,omitempty"`
+	// symbol of the native currency, e.g. ETH or FTM
+	Symbol string `json:"symbol,omitempty"`
+	// the --from address when none is given
+	From string    `json:"from,omitempty"`
+	Fees FeePolicy `json:"fees,omitempty"`
+}
+
+// FeePolicy adjusts the fees suggested by the nodes of a network
+type FeePolicy struct {
+	// NoTip leaves out the priority fee, e.g. on chains without london
+	NoTip bool `json:"noTip,omitempty"`
+	// GasPriceMultiplier scales the suggested gas price and tip, e.g. 1.2 to be included sooner
+	GasPriceMultiplier float64 `json:"gasPriceMultiplier,omitempty"`
+	// MaxGasPriceGwei refuses transaction params with a higher gas price
+	MaxGasPriceGwei float64 `json:"maxGasPriceGwei,omitempty"`
+}
+
+// EndpointConfig is an endpoint url with its credentials and limits. In the config file it
+// can also be given as a plain url string.
+type EndpointConfig struct {
+	Url           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	JwtSecretFile string            `json:"jwtSecretFile,omitempty"`
+	// requests per second, with bursts of up to RateBurst requests
+	RateLimit     float64 `json:"rateLimit,omitempty"`
+	RateBurst     int     `json:"rateBurst,omitempty"`
+	MaxConcurrent int     `json:"maxConcurrent,omitempty"`
+	// keep-alive connections kept open to the endpoint
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+	// tls settings, the files are pem encoded
+	CaFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	// a http, https or socks5 proxy url
+	Proxy       string   `json:"proxy,omitempty"`
+	DialTimeout Duration `json:"dialTimeout,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	// gzip request bodies of at least this many bytes
+	GzipMinSize int `json:"gzipMinSize,omitempty"`
+}
+
+// Duration is given as a string like "1m30s" or a number of seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (e *EndpointConfig) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*e = EndpointConfig{Url: url}
+		return nil
+	}
+	type plain EndpointConfig
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jeth", "config.json")
+}
+
+// Load reads the config file, a missing file returns an empty config
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) Network(name string) (*NetworkConfig, error) {
+	network, ok := c.Networks[name]
+	if !ok {
+		return nil, fmt.Errorf("network %q not found in config", name)
+	}
+	if len(network.Endpoints) == 0 {
+		return nil, fmt.Errorf("network %q has no endpoints", name)
+	}
+	network.Name = name
+	return &network, nil
+}
+
+func (c *Config) EndpointGroup(name string) ([]EndpointConfig, error) {
+	endpoints, ok := c.Endpoints[name]
+	if !ok || len(endpoints) == 0 {
+		return nil, fmt.Errorf("endpoint group %q not found in config", name)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,113 @@
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jaanek/jeth/abi"
+	"github.com/jaanek/jeth/flags"
+	"github.com/urfave/cli"
+)
+
+// hasMethodFromCli tells whether a method is given in --method or as the first argument
+func hasMethodFromCli(ctx *cli.Context) bool {
+	return ctx.IsSet(flags.MethodParam.Name) || ctx.NArg() > 0
+}
+
+// argsFromCli returns the arguments of the command. cli keeps the -- that ends the flags when
+// it follows an argument, e.g. in transfer(address,int256) 0xa11ce -- -5, it is dropped.
+func argsFromCli(ctx *cli.Context) []string {
+	args := ctx.Args()
+	for i, arg := range args {
+		if arg == "--" {
+			return append(append([]string{}, args[:i]...), args[i+1:]...)
+		}
+	}
+	return args
+}
+
+// methodFromCli returns the name and argument types of the method given in --method or as
+// the first argument, e.g. transfer(address,uint256) or transfer:address,uint256. The
+// arguments that follow the method are returned as its values.
+func methodFromCli(ctx *cli.Context) (string, []string, []string, error) {
+	args := argsFromCli(ctx)
+	methodStr := ctx.String(flags.MethodParam.Name)
+	if !ctx.IsSet(flags.MethodParam.Name) {
+		if len(args) == 0 {
+			return "", nil, nil, errors.New(fmt.Sprintf("Missing method, give it as the first argument or in --%s", flags.MethodParam.Name))
+		}
+		methodStr, args = args[0], args[1:]
+	}
+	errMsg := fmt.Sprintf("Method needs to be specified in format (example): transfer(address,uint256), got %q", methodStr)
+	var name, types string
+	if open := strings.Index(methodStr, "("); open >= 0 {
+		if !strings.HasSuffix(methodStr, ")") {
+			return "", nil, nil, errors.New(errMsg)
+		}
+		name, types = methodStr[:open], methodStr[open+1:len(methodStr)-1]
+	} else {
+		split := strings.Split(methodStr, ":")
+		if len(split) != 2 {
+			return "", nil, nil, errors.New(errMsg)
+		}
+		name, types = split[0], split[1]
+	}
+	if name == "" {
+		return "", nil, nil, errors.New(errMsg)
+	}
+	return name, splitTypes(types), args, nil
+}
+
+// splitTypes splits a comma separated list of types at its top level commas, so a tuple
+// type like (uint256,address) stays whole. An empty list has no types.
+func splitTypes(types string) []string {
+	if strings.TrimSpace(types) == "" {
+		return nil
+	}
+	var (
+		split []string
+		depth int
+		start int
+	)
+	for i, c := range types {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				split = append(split, strings.TrimSpace(types[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(split, strings.TrimSpace(types[start:]))
+}
+
+func abiPackedValuesFromCli(ctx *cli.Context, typeNames []string, args []string) (abi.Arguments, []byte, error) {
+	argTypes, err := abi.TypesFromStrings(typeNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	argValues, err := ValuesFromCli(ctx, argTypes, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	packedValues, err := abi.PackValues(argTypes, argValues)
+	return argTypes, packedValues, err
+}
+
+// ValuesFromCli returns a value for each of inputs, the values of repeated --arg flags come
+// first and then args
+func ValuesFromCli(ctx *cli.Context, inputs abi.Arguments, args []string) ([]string, error) {
+	values := append(ctx.StringSlice(flags.ArgParam.Name), args...)
+	if len(values) < len(inputs) {
+		return nil, fmt.Errorf("missing argument %d of type %s", len(values)+1, inputs[len(values)].Type)
+	}
+	if len(values) > len(inputs) {
+		return nil, fmt.Errorf("too many arguments, expected %d but got %d", len(inputs), len(values))
+	}
+	return values, nil
+}
@@ -1,6 +1,7 @@
 package eth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -14,7 +15,15 @@ import (
 	"github.com/urfave/cli"
 )
 
-func GetAccountBalanceCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+// BalanceOutput is the output of balance, its text format is the balance
+type BalanceOutput struct {
+	// Address is the checksummed address of --param
+	Address string `json:"address"`
+	// Balance is decimal wei
+	Balance string `json:"balance"`
+}
+
+func GetAccountBalanceCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate args
 	if !ctx.IsSet(flags.HexParam.Name) {
 		return errors.New(fmt.Sprintf("Missing address --%s", flags.HexParam.Name))
@@ -27,24 +36,27 @@ func GetAccountBalanceCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 	fromAddr := common.BytesToAddress(data)
 
 	// call
-	balance, err := GetAccountBalance(term, endpoint, fromAddr)
+	balance, err := GetAccountBalanceContext(c, term, endpoint, fromAddr)
 	if err != nil {
 		return err
 	}
+	out := BalanceOutput{Address: fromAddr.Hex(), Balance: balance.ToBig().String()}
 	if ctx.IsSet(flags.Verbose.Name) {
-		term.Output(fmt.Sprintf("%s: %v\n", input, balance))
-		return nil
+		return term.Render(ui.WithText(out, fmt.Sprintf("%s: %v", input, balance)))
 	}
-	term.Output(fmt.Sprintf("%v\n", balance))
-	return nil
+	return term.Render(ui.WithText(out, out.Balance))
 }
 
 func GetAccountBalance(term ui.Screen, endpoint rpc.Endpoint, fromAddr common.Address) (*uint256.Int, error) {
+	return GetAccountBalanceContext(context.Background(), term, endpoint, fromAddr)
+}
+
+func GetAccountBalanceContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, fromAddr common.Address) (*uint256.Int, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_getBalance", StringsToInterfaces([]string{fromAddr.Hex(), "latest"}), &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_getBalance", StringsToInterfaces([]string{fromAddr.Hex(), "latest"}), &result)
 	if err != nil {
 		return nil, err
 	}
-	return uint256.FromHex(resp.Result)
+	return uint256.FromHex(result)
 }
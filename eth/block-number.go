@@ -1,7 +1,7 @@
 package eth
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/httpclient"
@@ -10,21 +10,30 @@ import (
 	"github.com/urfave/cli"
 )
 
-func BlockNumberCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
-	blockNumber, err := BlockNumber(term, endpoint)
+// BlockNumberOutput is the output of block-number
+type BlockNumberOutput struct {
+	// BlockNumber is decimal
+	BlockNumber string `json:"blockNumber"`
+}
+
+func BlockNumberCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+	blockNumber, err := BlockNumberContext(c, term, endpoint)
 	if err != nil {
 		return err
 	}
-	term.Output(fmt.Sprintf("%s\n", blockNumber))
-	return nil
+	return term.Render(BlockNumberOutput{BlockNumber: blockNumber.ToBig().String()})
 }
 
 func BlockNumber(term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
+	return BlockNumberContext(context.Background(), term, endpoint)
+}
+
+func BlockNumberContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_blockNumber", []interface{}{}, &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_blockNumber", []interface{}{}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return uint256.FromHex(resp.Result)
+	return uint256.FromHex(result)
 }
@@ -1,11 +1,10 @@
 package eth
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
-	"strings"
 
 	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/abi"
@@ -29,12 +28,15 @@ type CallMethodParam struct {
 	GasPrice *string `json:"gasPrice,omitempty"`
 }
 
+// CallOutput is the output of call
 type CallOutput struct {
-	Result          string              `json:"result"`
+	// Result is the hex encoded return data
+	Result string `json:"result"`
+	// UnpackedResults are the values of the return data as the types of --out
 	UnpackedResults []abi.UnpackedValue `json:"unpacked"`
 }
 
-func CallMethodCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+func CallMethodCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate args
 	var fromAddr *common.Address
 	if ctx.IsSet(flags.FromParam.Name) {
@@ -63,19 +65,11 @@ func CallMethodCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint)
 		value.SetFromBig(valbig)
 	}
 
-	if !ctx.IsSet(flags.MethodParam.Name) {
-		return errors.New(fmt.Sprintf("Missing method param --%s", flags.MethodParam.Name))
-	}
-	errMsg := fmt.Sprintf("Method call needs to be specified in format (example): --%s=transfer:address,uint256", flags.MethodParam.Name)
-	methodStr := ctx.String(flags.MethodParam.Name)
-	methodSplit := strings.Split(methodStr, ":")
-	if len(methodSplit) != 2 {
-		return errors.New(errMsg)
+	methodName, typeNames, args, err := methodFromCli(ctx)
+	if err != nil {
+		return err
 	}
-	methodName := methodSplit[0]
-	var packedValues []byte
-	typeNames := strings.Split(methodSplit[1], ",")
-	argTypes, packedValues, err := abiPackedValuesFromCli(ctx, typeNames)
+	argTypes, packedValues, err := abiPackedValuesFromCli(ctx, typeNames, args)
 	if err != nil {
 		return err
 	}
@@ -83,15 +77,21 @@ func CallMethodCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint)
 	data := append(method.Id[:], packedValues...)
 
 	// call
-	result, err := CallMethod(term, endpoint, fromAddr, toAddr, value, data, Latest)
+	result, err := CallMethodContext(c, term, endpoint, fromAddr, toAddr, value, data, Latest)
 	if err != nil {
+		var e *rpc.RpcError
+		if errors.As(err, &e) {
+			if reason, ok := e.RevertReason(); ok {
+				return fmt.Errorf("%w (reason: %s)", err, reason)
+			}
+		}
 		return err
 	}
 	out := CallOutput{
 		Result: hexutil.Encode(result),
 	}
 	if ctx.IsSet(flags.OutputTypesParam.Name) {
-		typeNames := strings.Split(ctx.String(flags.OutputTypesParam.Name), ",")
+		typeNames := splitTypes(ctx.String(flags.OutputTypesParam.Name))
 		outTypes, err := abi.TypesFromStrings(typeNames)
 		if err != nil {
 			return err
@@ -102,19 +102,14 @@ func CallMethodCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint)
 		}
 		out.UnpackedResults = results
 	}
-	if ctx.IsSet(flags.Plain.Name) {
-		term.Print(fmt.Sprintf("result: %s", out.Result))
-		term.Print(fmt.Sprintf("unpacked results: %+v", out.UnpackedResults))
-	}
-	b, err := json.Marshal(&out)
-	if err != nil {
-		return err
-	}
-	term.Output(fmt.Sprintf("%s\n", string(b)))
-	return nil
+	return term.Render(out)
 }
 
 func CallMethod(term ui.Screen, endpoint rpc.Endpoint, from *common.Address, to common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) ([]byte, error) {
+	return CallMethodContext(context.Background(), term, endpoint, from, to, value, data, tag)
+}
+
+func CallMethodContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from *common.Address, to common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) ([]byte, error) {
 	param := CallMethodParam{
 		To:   to.Hex(),
 		Data: hexutil.Encode(data),
@@ -126,10 +121,10 @@ func CallMethod(term ui.Screen, endpoint rpc.Endpoint, from *common.Address, to
 		param.Value = value.Hex()
 	}
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_call", []interface{}{param, tag}, &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_call", []interface{}{param, tag}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return hexutil.Decode(resp.Result)
+	return hexutil.Decode(result)
 }
@@ -1,7 +1,7 @@
 package eth
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/httpclient"
@@ -10,21 +10,30 @@ import (
 	"github.com/urfave/cli"
 )
 
-func ChainIdCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
-	chainId, err := ChainId(term, endpoint)
+// ChainIdOutput is the output of chain-id
+type ChainIdOutput struct {
+	// ChainId is decimal
+	ChainId string `json:"chainId"`
+}
+
+func ChainIdCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+	chainId, err := ChainIdContext(c, term, endpoint)
 	if err != nil {
 		return err
 	}
-	term.Output(fmt.Sprintf("%s\n", chainId))
-	return nil
+	return term.Render(ChainIdOutput{ChainId: chainId.ToBig().String()})
 }
 
 func ChainId(term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
+	return ChainIdContext(context.Background(), term, endpoint)
+}
+
+func ChainIdContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_chainId", []interface{}{}, &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_chainId", []interface{}{}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return uint256.FromHex(resp.Result)
+	return uint256.FromHex(result)
 }
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"os"
 	"strings"
+
+	"github.com/holiman/uint256"
 )
 
 func StringsToInterfaces(arr []string) []interface{} {
@@ -14,6 +16,15 @@ func StringsToInterfaces(arr []string) []interface{} {
 	return result
 }
 
+func HexToUint64(hex string) (*uint64, error) {
+	val, err := uint256.FromHex(hex)
+	if err != nil {
+		return nil, err
+	}
+	result := val.ToBig().Uint64()
+	return &result, nil
+}
+
 func StdInReadAll() string {
 	arr := make([]string, 0)
 	scanner := bufio.NewScanner(os.Stdin)
@@ -2,9 +2,11 @@ package eth
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
 
 	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/flags"
@@ -29,7 +31,13 @@ type EstimateGasParam struct {
 	GasPrice *string `json:"gasPrice,omitempty"`
 }
 
-func EstimateGasCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+// EstimateGasOutput is the output of estimate-gas
+type EstimateGasOutput struct {
+	// Gas is decimal
+	Gas string `json:"gas"`
+}
+
+func EstimateGasCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate args
 	if !ctx.IsSet(flags.FromParam.Name) {
 		return errors.New(fmt.Sprintf("Missing from address --%s", flags.FromParam.Name))
@@ -67,15 +75,14 @@ func EstimateGasCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint)
 	value.SetFromBig(valbig)
 
 	// call
-	gas, err := EstimateGas(term, endpoint, fromAddr, toAddr, value, data, "latest")
+	gas, err := EstimateGasContext(c, term, endpoint, fromAddr, toAddr, value, data, "latest")
 	if err != nil {
 		return err
 	}
-	term.Output(fmt.Sprintf("%d\n", gas))
-	return nil
+	return term.Render(EstimateGasOutput{Gas: strconv.FormatUint(*gas, 10)})
 }
 
-func EstimateGas(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) (*uint64, error) {
+func NewEstimateGasParam(from common.Address, to *common.Address, value *uint256.Int, data []byte) EstimateGasParam {
 	params := EstimateGasParam{
 		From: from.Hex(),
 		Data: hexutil.Encode(data),
@@ -86,18 +93,22 @@ func EstimateGas(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to
 	if to != nil {
 		params.To = to.Hex()
 	}
+	return params
+}
+
+func EstimateGas(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) (*uint64, error) {
+	return EstimateGasContext(context.Background(), term, endpoint, from, to, value, data, tag)
+}
+
+func EstimateGasContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) (*uint64, error) {
+	params := NewEstimateGasParam(from, to, value, data)
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_estimateGas", []interface{}{params, tag}, &resp)
-	if err != nil {
-		return nil, err
-	}
-	val, err := uint256.FromHex(resp.Result)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_estimateGas", []interface{}{params, tag}, &result)
 	if err != nil {
 		return nil, err
 	}
-	gas := val.ToBig().Uint64()
-	return &gas, nil
+	return HexToUint64(result)
 }
 
 func DecodeTransaction(input string) (types.Transaction, error) {
@@ -77,7 +77,9 @@ type Method interface {
 	Inputs() abi.Arguments
 	Outputs() abi.Arguments
 	Send(from common.Address, to common.Address, value *uint256.Int, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error)
+	SendContext(ctx context.Context, from common.Address, to common.Address, value *uint256.Int, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error)
 	Call(from *common.Address, to common.Address, value *uint256.Int, values []string) ([]byte, []abi.UnpackedValue, error)
+	CallContext(ctx context.Context, from *common.Address, to common.Address, value *uint256.Int, values []string) ([]byte, []abi.UnpackedValue, error)
 }
 
 func NewMethod(term ui.Screen, endpoint rpc.Endpoint, methodName string, inputs []string, outputs []string) (Method, error) {
@@ -115,43 +117,27 @@ func (m *method) UnpackResult(result []byte) ([]abi.UnpackedValue, error) {
 type GetSignedTxCallback = func(term ui.Screen, chainID uint256.Int, nonce uint64, from common.Address, to *common.Address, value *uint256.Int, input []byte, gasLimit uint64, gasPrice, gasTip, gasFeeCap *uint256.Int) ([]byte, error)
 
 func (m *method) Send(from common.Address, to common.Address, value *uint256.Int, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
+	return m.SendContext(context.Background(), from, to, value, values, waitTime, txSigner)
+}
+
+func (m *method) SendContext(ctx context.Context, from common.Address, to common.Address, value *uint256.Int, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
 	data, err := m.PackedCall(values)
 	if err != nil {
 		return "", nil, fmt.Errorf("Error while packing method call: %w", err)
 	}
-
-	// estimate params, gas etc.
-	params, err := GetTransactionParams(m.term, m.endpoint, from, &to, value, data, Latest)
-	if err != nil {
-		return "", nil, fmt.Errorf("Error while getting tx params for a method call: %w", err)
-	}
-
-	// get signed tx and send it
-	encoded, err := txSigner.GetSignedRawTx(*params.ChainId, *params.TxCount, from, &to, value, data, *params.Gas, params.GasPrice, params.GasTip, params.GasPrice)
-	hash, err := SendTransaction(m.term, m.endpoint, encoded)
-	if err != nil {
-		return "", nil, fmt.Errorf("Failed to send tx: %w", err)
-	}
-	// m.term.Print(fmt.Sprintf("Tx hash: %s", hash))
-
-	// wait for tx receipt
-	if waitTime < 0 {
-		waitTime = ReceiptWaitTime
-	}
-	c, _ := context.WithTimeout(context.Background(), waitTime)
-	receipt, err := WaitTransactionReceipt(c, m.term, m.endpoint, hash)
-	if err != nil {
-		return hash, nil, err
-	}
-	return hash, receipt, nil
+	return sendAndWait(ctx, m.term, m.endpoint, from, &to, value, data, waitTime, txSigner)
 }
 
 func (m *method) Call(from *common.Address, to common.Address, value *uint256.Int, values []string) ([]byte, []abi.UnpackedValue, error) {
+	return m.CallContext(context.Background(), from, to, value, values)
+}
+
+func (m *method) CallContext(ctx context.Context, from *common.Address, to common.Address, value *uint256.Int, values []string) ([]byte, []abi.UnpackedValue, error) {
 	data, err := m.PackedCall(values)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Error while packing method call: %w", err)
 	}
-	result, err := CallMethod(m.term, m.endpoint, from, to, value, data, Latest)
+	result, err := CallMethodContext(ctx, m.term, m.endpoint, from, to, value, data, Latest)
 	if err != nil {
 		return nil, nil, err
 	}
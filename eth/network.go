@@ -0,0 +1,96 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+	"github.com/jaanek/jeth/config"
+	"github.com/jaanek/jeth/flags"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/ledgerwatch/erigon/params"
+	"github.com/urfave/cli"
+)
+
+// NetworkFromCli returns the network named by --network from the config file, nil when
+// none is given
+func NetworkFromCli(ctx *cli.Context) (*config.NetworkConfig, error) {
+	if !ctx.IsSet(flags.Network.Name) {
+		return nil, nil
+	}
+	cfg, err := config.Load(config.DefaultPath())
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Network(ctx.String(flags.Network.Name))
+}
+
+type networkKey struct{}
+
+// WithNetwork returns a context carrying network, the transactions sent with it are held to
+// the chain id and fee policy of the network
+func WithNetwork(ctx context.Context, network *config.NetworkConfig) context.Context {
+	if network == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, networkKey{}, network)
+}
+
+// NetworkFromContext returns the network of WithNetwork, nil when there is none
+func NetworkFromContext(ctx context.Context) *config.NetworkConfig {
+	network, _ := ctx.Value(networkKey{}).(*config.NetworkConfig)
+	return network
+}
+
+// applyNetwork checks that p is for the chain of network and applies its fee policy
+func applyNetwork(p *TransactionParams, network *config.NetworkConfig) error {
+	if network == nil {
+		return nil
+	}
+	if network.ChainId != 0 && p.ChainId.Uint64() != network.ChainId {
+		return fmt.Errorf("%w: %s is on chain %s, network %s expects %d", rpc.ErrWrongChain, rpc.RedactUrl(p.Endpoint.Url()), p.ChainId, network.Name, network.ChainId)
+	}
+	return ApplyFeePolicy(p, network.Fees)
+}
+
+// ApplyFeePolicy scales the suggested fees of p and checks them against the limits of the
+// policy
+func ApplyFeePolicy(p *TransactionParams, policy config.FeePolicy) error {
+	if policy.NoTip {
+		p.GasTip = nil
+	}
+	if m := policy.GasPriceMultiplier; m > 0 && m != 1 {
+		p.GasPrice = scaleWei(p.GasPrice, m)
+		p.GasTip = scaleWei(p.GasTip, m)
+	}
+	return CheckMaxGasPrice(policy, p.GasPrice)
+}
+
+// CheckMaxGasPrice fails when the price per gas in wei is above the max of the policy
+func CheckMaxGasPrice(policy config.FeePolicy, gasPrice *uint256.Int) error {
+	if policy.MaxGasPriceGwei <= 0 || gasPrice == nil {
+		return nil
+	}
+	max := new(big.Float).Mul(big.NewFloat(policy.MaxGasPriceGwei), big.NewFloat(params.GWei))
+	if new(big.Float).SetInt(gasPrice.ToBig()).Cmp(max) > 0 {
+		return fmt.Errorf("gas price of %s gwei is above the max of %v gwei", formatGwei(gasPrice), policy.MaxGasPriceGwei)
+	}
+	return nil
+}
+
+func scaleWei(wei *uint256.Int, m float64) *uint256.Int {
+	if wei == nil {
+		return nil
+	}
+	scaled, _ := new(big.Float).Mul(new(big.Float).SetInt(wei.ToBig()), big.NewFloat(m)).Int(nil)
+	result, overflow := uint256.FromBig(scaled)
+	if overflow {
+		return wei
+	}
+	return result
+}
+
+func formatGwei(wei *uint256.Int) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei.ToBig()), big.NewFloat(params.GWei)).Text('f', -1)
+}
@@ -1,7 +1,7 @@
 package eth
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/flags"
@@ -12,46 +12,64 @@ import (
 	"github.com/urfave/cli"
 )
 
-func GasPriceCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
-	gasPrice, err := GasPrice(term, endpoint)
+// GasPriceOutput is the output of gas-price
+type GasPriceOutput struct {
+	// GasPrice is decimal wei, or gwei with --gwei
+	GasPrice string `json:"gasPrice"`
+}
+
+// GasTipOutput is the output of tip
+type GasTipOutput struct {
+	// GasTip is decimal wei, or gwei with --gwei
+	GasTip string `json:"gasTip"`
+}
+
+func GasPriceCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+	gasPrice, err := GasPriceContext(c, term, endpoint)
 	if err != nil {
 		return err
 	}
 	if ctx.IsSet(flags.Gwei.Name) {
 		gasPrice = new(uint256.Int).Div(gasPrice, new(uint256.Int).SetUint64(params.GWei))
 	}
-	term.Output(fmt.Sprintf("%s\n", gasPrice))
-	return nil
+	return term.Render(GasPriceOutput{GasPrice: gasPrice.ToBig().String()})
 }
 
 func GasPrice(term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
+	return GasPriceContext(context.Background(), term, endpoint)
+}
+
+func GasPriceContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_gasPrice", []interface{}{}, &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_gasPrice", []interface{}{}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return uint256.FromHex(resp.Result)
+	return uint256.FromHex(result)
 }
 
-func MaxPriorityFeePerGasCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
-	maxTip, err := MaxPriorityFeePerGas(term, endpoint)
+func MaxPriorityFeePerGasCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+	maxTip, err := MaxPriorityFeePerGasContext(c, term, endpoint)
 	if err != nil {
 		return err
 	}
 	if ctx.IsSet(flags.Gwei.Name) {
 		maxTip = new(uint256.Int).Div(maxTip, new(uint256.Int).SetUint64(params.GWei))
 	}
-	term.Output(fmt.Sprintf("%s\n", maxTip))
-	return nil
+	return term.Render(GasTipOutput{GasTip: maxTip.ToBig().String()})
 }
 
 func MaxPriorityFeePerGas(term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
+	return MaxPriorityFeePerGasContext(context.Background(), term, endpoint)
+}
+
+func MaxPriorityFeePerGasContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint) (*uint256.Int, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_maxPriorityFeePerGas", []interface{}{}, &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_maxPriorityFeePerGas", []interface{}{}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return uint256.FromHex(resp.Result)
+	return uint256.FromHex(result)
 }
@@ -19,6 +19,10 @@ type TxSigner interface {
 }
 
 func Deploy(term ui.Screen, endpoint rpc.Endpoint, from common.Address, bin []byte, value *uint256.Int, typeNames []string, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
+	return DeployContext(context.Background(), term, endpoint, from, bin, value, typeNames, values, waitTime, txSigner)
+}
+
+func DeployContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, bin []byte, value *uint256.Int, typeNames []string, values []string, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
 	argTypes, err := abi.TypesFromStrings(typeNames)
 	if err != nil {
 		return "", nil, err
@@ -28,57 +32,53 @@ func Deploy(term ui.Screen, endpoint rpc.Endpoint, from common.Address, bin []by
 		return "", nil, err
 	}
 	data := append(bin, packedValues...)
-
-	// estimate params, gas etc.
-	params, err := GetTransactionParams(term, endpoint, from, nil, value, data, Latest)
-	if err != nil {
-		return "", nil, fmt.Errorf("Error while getting tx params for a method call: %w", err)
-	}
-
-	// get signed tx and send it
-	encoded, err := txSigner.GetSignedRawTx(*params.ChainId, *params.TxCount, from, nil, value, data, *params.Gas, params.GasPrice, params.GasTip, params.GasPrice)
-	hash, err := SendTransaction(term, endpoint, encoded)
-	if err != nil {
-		return "", nil, fmt.Errorf("Failed to send tx: %w", err)
-	}
-	// m.term.Print(fmt.Sprintf("Tx hash: %s", hash))
-
-	// wait for tx receipt
-	if waitTime < 0 {
-		waitTime = ReceiptWaitTime
-	}
-	c, _ := context.WithTimeout(context.Background(), waitTime)
-	receipt, err := WaitTransactionReceipt(c, term, endpoint, hash)
-	if err != nil {
-		return hash, nil, err
-	}
-	return hash, receipt, nil
+	return sendAndWait(ctx, term, endpoint, from, nil, value, data, waitTime, txSigner)
 }
 
 func SendValue(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to common.Address, value *uint256.Int, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
 	return Send(term, endpoint, from, to, value, []byte{}, waitTime, txSigner)
 }
 
+func SendValueContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, to common.Address, value *uint256.Int, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
+	return SendContext(ctx, term, endpoint, from, to, value, []byte{}, waitTime, txSigner)
+}
+
 func Send(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to common.Address, value *uint256.Int, data []byte, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
+	return SendContext(context.Background(), term, endpoint, from, to, value, data, waitTime, txSigner)
+}
+
+func SendContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, to common.Address, value *uint256.Int, data []byte, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
+	return sendAndWait(ctx, term, endpoint, from, &to, value, data, waitTime, txSigner)
+}
+
+// sendAndWait signs and sends a tx with estimated params and waits for its receipt, a nil
+// to address deploys a contract. The fee policy of the network of ctx applies to the params.
+func sendAndWait(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, waitTime time.Duration, txSigner TxSigner) (string, *TxReceipt, error) {
 	// estimate params, gas etc.
-	params, err := GetTransactionParams(term, endpoint, from, &to, value, data, Latest)
+	params, err := GetTransactionParamsContext(ctx, term, endpoint, from, to, value, data, Latest)
 	if err != nil {
 		return "", nil, fmt.Errorf("Error while getting tx params for a method call: %w", err)
 	}
+	if err := applyNetwork(params, NetworkFromContext(ctx)); err != nil {
+		return "", nil, err
+	}
 
 	// get signed tx and send it
-	encoded, err := txSigner.GetSignedRawTx(*params.ChainId, *params.TxCount, from, &to, value, data, *params.Gas, params.GasPrice, params.GasTip, params.GasPrice)
-	hash, err := SendTransaction(term, endpoint, encoded)
+	encoded, err := txSigner.GetSignedRawTx(*params.ChainId, *params.TxCount, from, to, value, data, *params.Gas, params.GasPrice, params.GasTip, params.GasPrice)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to sign tx: %w", err)
+	}
+	hash, err := SendTransactionContext(ctx, term, endpoint, encoded)
 	if err != nil {
 		return "", nil, fmt.Errorf("Failed to send tx: %w", err)
 	}
-	// m.term.Print(fmt.Sprintf("Tx hash: %s", hash))
 
 	// wait for tx receipt
 	if waitTime < 0 {
 		waitTime = ReceiptWaitTime
 	}
-	c, _ := context.WithTimeout(context.Background(), waitTime)
+	c, cancel := context.WithTimeout(ctx, waitTime)
+	defer cancel()
 	receipt, err := WaitTransactionReceipt(c, term, endpoint, hash)
 	if err != nil {
 		return hash, nil, err
@@ -0,0 +1,62 @@
+package eth_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/jaanek/jeth/config"
+	"github.com/jaanek/jeth/eth"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common"
+)
+
+var errNotSigned = errors.New("not signed")
+
+// refusingSigner records the gas price it was asked to sign with
+type refusingSigner struct {
+	gasPrice *uint256.Int
+}
+
+func (s *refusingSigner) GetSignedRawTx(chainID uint256.Int, nonce uint64, from common.Address, to *common.Address, value *uint256.Int, input []byte, gasLimit uint64, gasPrice, gasTip, gasFeeCap *uint256.Int) ([]byte, error) {
+	s.gasPrice = gasPrice
+	return nil, errNotSigned
+}
+
+func TestSendAppliesFeePolicyOfNetwork(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	term := rpctest.NewScreen("")
+	to := common.HexToAddress("0x02")
+
+	tests := []struct {
+		name    string
+		network *config.NetworkConfig
+		price   uint64
+		err     string
+	}{
+		{name: "no network", price: 1000000000},
+		{name: "scaled", network: &config.NetworkConfig{Fees: config.FeePolicy{GasPriceMultiplier: 2}}, price: 2000000000},
+		{name: "above the max", network: &config.NetworkConfig{Fees: config.FeePolicy{MaxGasPriceGwei: 0.5}}, err: "above the max of 0.5 gwei"},
+		{name: "other chain", network: &config.NetworkConfig{Name: "main", ChainId: 1}, err: "network main expects 1"},
+	}
+	for _, test := range tests {
+		signer := &refusingSigner{}
+		ctx := eth.WithNetwork(context.Background(), test.network)
+		_, _, err := eth.SendValueContext(ctx, term, server.Endpoint(), common.HexToAddress("0x01"), to, uint256.NewInt(1), 0, signer)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) || signer.gasPrice != nil {
+				t.Errorf("%s: got %v, want an error with %q before signing", test.name, err, test.err)
+			}
+			continue
+		}
+		if !errors.Is(err, errNotSigned) {
+			t.Fatalf("%s: got %v, want %v", test.name, err, errNotSigned)
+		}
+		if signer.gasPrice.Uint64() != test.price {
+			t.Errorf("%s: signed with gas price %s, want %d", test.name, signer.gasPrice, test.price)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/core/types"
+)
+
+type BlockHeader struct {
+	Hash          string `json:"hash"`
+	ParentHash    string `json:"parentHash"`
+	Number        string `json:"number"`
+	Timestamp     string `json:"timestamp"`
+	Miner         string `json:"miner"`
+	GasLimit      string `json:"gasLimit"`
+	GasUsed       string `json:"gasUsed"`
+	BaseFeePerGas string `json:"baseFeePerGas,omitempty"`
+}
+
+type LogFilter struct {
+	Address []string   `json:"address,omitempty"`
+	Topics  [][]string `json:"topics,omitempty"`
+}
+
+// SubscribeNewHeads delivers a header for each new block added to the chain
+func SubscribeNewHeads(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, ch chan<- *BlockHeader) (*rpc.Subscription, error) {
+	return subscribe(ctx, term, endpoint, []interface{}{rpc.NewHeads}, func(msg json.RawMessage, done <-chan struct{}) {
+		header := &BlockHeader{}
+		if err := json.Unmarshal(msg, header); err != nil {
+			term.Errorf("failed to decode new head: %v\n", err)
+			return
+		}
+		select {
+		case ch <- header:
+		case <-done:
+		}
+	})
+}
+
+// SubscribeLogs delivers logs matching the filter that are included in new blocks
+func SubscribeLogs(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, filter LogFilter, ch chan<- types.Log) (*rpc.Subscription, error) {
+	return subscribe(ctx, term, endpoint, []interface{}{rpc.Logs, filter}, func(msg json.RawMessage, done <-chan struct{}) {
+		var log types.Log
+		if err := json.Unmarshal(msg, &log); err != nil {
+			term.Errorf("failed to decode log: %v\n", err)
+			return
+		}
+		select {
+		case ch <- log:
+		case <-done:
+		}
+	})
+}
+
+// SubscribeNewPendingTransactions delivers hashes of transactions added to the pending state
+func SubscribeNewPendingTransactions(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, ch chan<- string) (*rpc.Subscription, error) {
+	return subscribe(ctx, term, endpoint, []interface{}{rpc.NewPendingTransactions}, func(msg json.RawMessage, done <-chan struct{}) {
+		var hash string
+		if err := json.Unmarshal(msg, &hash); err != nil {
+			term.Errorf("failed to decode pending tx hash: %v\n", err)
+			return
+		}
+		select {
+		case ch <- hash:
+		case <-done:
+		}
+	})
+}
+
+func subscribe(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, params []interface{}, forward func(msg json.RawMessage, done <-chan struct{})) (*rpc.Subscription, error) {
+	raw := make(chan json.RawMessage, 16)
+	sub, err := rpc.Subscribe(ctx, term, endpoint, raw, params)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case msg := <-raw:
+				forward(msg, sub.Done())
+			case <-sub.Done():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -1,10 +1,11 @@
 package eth
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 
-	"github.com/holiman/uint256"
 	"github.com/jaanek/jeth/flags"
 	"github.com/jaanek/jeth/httpclient"
 	"github.com/jaanek/jeth/rpc"
@@ -21,7 +22,15 @@ const (
 	Pending = BlockPositionTag("pending")
 )
 
-func TransactionsCountCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+// TransactionsCountOutput is the output of tx-count, its text format is the count
+type TransactionsCountOutput struct {
+	// Address is the checksummed address of --param
+	Address string `json:"address"`
+	// TxCount is the decimal count of the latest block
+	TxCount string `json:"txCount"`
+}
+
+func TransactionsCountCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate input
 	if !ctx.IsSet(flags.HexParam.Name) {
 		return errors.New(fmt.Sprintf("Missing from address in hex --%s", flags.HexParam.Name))
@@ -33,25 +42,25 @@ func TransactionsCountCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 	}
 
 	// call
-	count, err := TransactionsCount(term, endpoint, common.BytesToAddress(data), Latest)
+	address := common.BytesToAddress(data)
+	count, err := TransactionsCountContext(c, term, endpoint, address, Latest)
 	if err != nil {
 		return err
 	}
-	term.Output(fmt.Sprintf("%s\n", count))
-	return nil
+	out := TransactionsCountOutput{Address: address.Hex(), TxCount: strconv.FormatUint(*count, 10)}
+	return term.Render(ui.WithText(out, out.TxCount))
 }
 
 func TransactionsCount(term ui.Screen, endpoint rpc.Endpoint, from common.Address, tag BlockPositionTag) (*uint64, error) {
+	return TransactionsCountContext(context.Background(), term, endpoint, from, tag)
+}
+
+func TransactionsCountContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, tag BlockPositionTag) (*uint64, error) {
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_getTransactionCount", []interface{}{from.Hex(), tag}, &resp)
-	if err != nil {
-		return nil, err
-	}
-	val, err := uint256.FromHex(resp.Result)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_getTransactionCount", []interface{}{from.Hex(), tag}, &result)
 	if err != nil {
 		return nil, err
 	}
-	count := val.ToBig().Uint64()
-	return &count, nil
+	return HexToUint64(result)
 }
@@ -1,19 +1,18 @@
 package eth
 
 import (
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/holiman/uint256"
-	"github.com/jaanek/jeth/abi"
 	"github.com/jaanek/jeth/flags"
+	"github.com/jaanek/jeth/httpclient"
 	"github.com/jaanek/jeth/rpc"
 	"github.com/jaanek/jeth/ui"
 	"github.com/ledgerwatch/erigon/common"
@@ -38,7 +37,10 @@ type TransactionParams struct {
 	Balance        *uint256.Int
 }
 
+// TransactionParamsOutput is the output of tx-params, the amounts are hex wei and the counts
+// decimal. Its fields are named like the flags they can be piped into with --stdin.
 type TransactionParamsOutput struct {
+	// RpcUrl keeps its credentials, tx-send reads it from stdin to reach the same node
 	RpcUrl         string `json:"rpcUrl"`
 	ChainId        string `json:"chainId"`
 	From           string `json:"from"`
@@ -54,7 +56,7 @@ type TransactionParamsOutput struct {
 	Balance        string `json:"balance"`
 }
 
-func TransactionParamsCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+func TransactionParamsCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate args
 	if !ctx.IsSet(flags.FromParam.Name) {
 		return errors.New(fmt.Sprintf("Missing from address --%s", flags.FromParam.Name))
@@ -88,26 +90,9 @@ func TransactionParamsCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 	// check data or method call
 	// https://docs.soliditylang.org/en/develop/abi-spec.html
 	var data = []byte{}
+	var methodSig string
 	if ctx.IsSet(flags.DataParam.Name) {
 		data = hexutil.MustDecode(ctx.String(flags.DataParam.Name))
-	} else if ctx.IsSet(flags.MethodParam.Name) {
-		errMsg := fmt.Sprintf("Method call needs to be specified in format (example): --%s=transfer:address,uint256", flags.MethodParam.Name)
-		methodStr := ctx.String(flags.MethodParam.Name)
-		methodSplit := strings.Split(methodStr, ":")
-		if len(methodSplit) != 2 {
-			return errors.New(errMsg)
-		}
-		methodName := methodSplit[0]
-		typeNames := strings.Split(methodSplit[1], ",")
-		if len(typeNames) == 0 {
-			return errors.New(errMsg)
-		}
-		argTypes, packedValues, err := abiPackedValuesFromCli(ctx, typeNames)
-		if err != nil {
-			return err
-		}
-		method := NewHashedMethod(methodName, argTypes)
-		data = append(method.Id[:], packedValues...)
 	} else if ctx.IsSet(flags.DeployParam.Name) {
 		var bin []byte
 		if ctx.IsSet(flags.BinParam.Name) {
@@ -128,16 +113,24 @@ func TransactionParamsCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 		} else {
 			return errors.New(fmt.Sprintf("Missing contract binary (init code) --%s", flags.BinParam.Name))
 		}
-		var packedValues []byte
-		typeNames := strings.Split(ctx.String(flags.DeployParam.Name), ",")
-		if len(typeNames) > 0 {
-			var err error
-			_, packedValues, err = abiPackedValuesFromCli(ctx, typeNames)
-			if err != nil {
-				return err
-			}
+		typeNames := splitTypes(ctx.String(flags.DeployParam.Name))
+		_, packedValues, err := abiPackedValuesFromCli(ctx, typeNames, argsFromCli(ctx))
+		if err != nil {
+			return err
 		}
 		data = append(bin, packedValues...)
+	} else if hasMethodFromCli(ctx) {
+		methodName, typeNames, args, err := methodFromCli(ctx)
+		if err != nil {
+			return err
+		}
+		argTypes, packedValues, err := abiPackedValuesFromCli(ctx, typeNames, args)
+		if err != nil {
+			return err
+		}
+		method := NewHashedMethod(methodName, argTypes)
+		data = append(method.Id[:], packedValues...)
+		methodSig = method.Sig
 	}
 
 	// either value or data needs to be specified
@@ -150,46 +143,55 @@ func TransactionParamsCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 	}
 
 	// call
-	p, err := GetTransactionParams(term, endpoint, fromAddr, toAddr, value, data, Latest)
+	p, err := GetTransactionParamsContext(c, term, endpoint, fromAddr, toAddr, value, data, Latest)
 	if err != nil {
 		return err
 	}
+	network := NetworkFromContext(c)
+	if err := applyNetwork(p, network); err != nil {
+		return err
+	}
+	symbol, balanceSymbol := "eth/ftm", "eth/ftm or chain native currency"
+	if network != nil {
+		if network.Symbol != "" {
+			symbol, balanceSymbol = network.Symbol, network.Symbol
+		}
+	}
 
 	// output results
-	if ctx.IsSet(flags.Plain.Name) {
-		valueInGwei := new(uint256.Int).Div(p.Value, new(uint256.Int).SetUint64(params.GWei))
-		term.Print(fmt.Sprintf("rpcUrl: %s", p.Endpoint))
-		term.Print(fmt.Sprintf("chainId: %s", p.ChainId))
-		term.Print(fmt.Sprintf("from: %s", p.From))
-		term.Print(fmt.Sprintf("to: %s", p.To))
-		term.Print(fmt.Sprintf("value: %s wei (%s gwei) (%.9f eth/ftm)", p.Value, valueInGwei, float64(valueInGwei.Uint64())/1e9))
-		term.Print(fmt.Sprintf("data: %x", p.Data))
-		if p.GasTip != nil {
-			gasTipInGwei := new(uint256.Int).Div(p.GasTip, new(uint256.Int).SetUint64(params.GWei))
-			term.Print(fmt.Sprintf("gasTip: %s wei (%s gwei)", p.GasTip, gasTipInGwei))
-		}
-		if p.GasPrice != nil {
-			gasPriceInGwei := new(uint256.Int).Div(p.GasPrice, new(uint256.Int).SetUint64(params.GWei))
-			term.Print(fmt.Sprintf("gasPrice: %s wei (%s gwei)", p.GasPrice, gasPriceInGwei))
-		}
-		term.Print(fmt.Sprintf("gas: %d", *p.Gas))
-		if p.TxCount != nil {
-			term.Print(fmt.Sprintf("txCountLatest: %d", *p.TxCount))
-		}
-		if p.TxCountPending != nil {
-			term.Print(fmt.Sprintf("txCountPending: %d", *p.TxCountPending))
-		}
-		if p.Balance != nil {
-			balanceInEth := new(uint256.Int).Div(p.Balance, new(uint256.Int).SetUint64(params.Ether))
-			term.Print(fmt.Sprintf("balance: %v (%s eth/ftm or chain native currency)", p.Balance, balanceInEth))
-		}
+	var text strings.Builder
+	valueInGwei := new(uint256.Int).Div(p.Value, new(uint256.Int).SetUint64(params.GWei))
+	fmt.Fprintf(&text, "rpcUrl: %s\n", rpc.RedactUrl(p.Endpoint.Url()))
+	fmt.Fprintf(&text, "chainId: %s\n", p.ChainId)
+	fmt.Fprintf(&text, "from: %s\n", p.From)
+	fmt.Fprintf(&text, "to: %s\n", p.To)
+	fmt.Fprintf(&text, "value: %s wei (%s gwei) (%.9f %s)\n", p.Value, valueInGwei, float64(valueInGwei.Uint64())/1e9, symbol)
+	fmt.Fprintf(&text, "data: %x\n", p.Data)
+	if p.GasTip != nil {
+		gasTipInGwei := new(uint256.Int).Div(p.GasTip, new(uint256.Int).SetUint64(params.GWei))
+		fmt.Fprintf(&text, "gasTip: %s wei (%s gwei)\n", p.GasTip, gasTipInGwei)
+	}
+	if p.GasPrice != nil {
+		gasPriceInGwei := new(uint256.Int).Div(p.GasPrice, new(uint256.Int).SetUint64(params.GWei))
+		fmt.Fprintf(&text, "gasPrice: %s wei (%s gwei)\n", p.GasPrice, gasPriceInGwei)
+	}
+	fmt.Fprintf(&text, "gas: %d\n", *p.Gas)
+	if p.TxCount != nil {
+		fmt.Fprintf(&text, "txCountLatest: %d\n", *p.TxCount)
+	}
+	if p.TxCountPending != nil {
+		fmt.Fprintf(&text, "txCountPending: %d\n", *p.TxCountPending)
+	}
+	if p.Balance != nil {
+		balanceInEth := new(uint256.Int).Div(p.Balance, new(uint256.Int).SetUint64(params.Ether))
+		fmt.Fprintf(&text, "balance: %v (%s %s)\n", p.Balance, balanceInEth, balanceSymbol)
 	}
 	out := TransactionParamsOutput{
 		RpcUrl:         p.Endpoint.Url(),
 		ChainId:        p.ChainId.Hex(),
 		From:           p.From.Hex(),
 		Data:           hexutil.Encode(data),
-		Method:         ctx.String(flags.MethodParam.Name),
+		Method:         methodSig,
 		GasPrice:       p.GasPrice.Hex(),
 		Gas:            strconv.FormatUint(*p.Gas, 10),
 		TxCount:        strconv.FormatUint(*p.TxCount, 10),
@@ -205,100 +207,82 @@ func TransactionParamsCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.End
 	if p.GasTip != nil && ctx.Bool(flags.NoTip.Name) == false {
 		out.GasTip = p.GasTip.Hex()
 	}
-	b, err := json.Marshal(&out)
-	if err != nil {
-		return err
-	}
-	term.Output(fmt.Sprintf("%s\n", string(b)))
-	return nil
+	return term.Render(ui.WithText(out, text.String()))
 }
 
 func GetTransactionParams(term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) (*TransactionParams, error) {
-	var wg sync.WaitGroup
-	var errs = make(chan error, 7)
-	var chainId, gasTip, gasPrice *uint256.Int
-	var gas, txCount, txCountPending *uint64
-	var fromBalance *uint256.Int
+	return GetTransactionParamsContext(context.Background(), term, endpoint, from, to, value, data, tag)
+}
+
+func GetTransactionParamsContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, from common.Address, to *common.Address, value *uint256.Int, data []byte, tag BlockPositionTag) (*TransactionParams, error) {
+	var tipResult, priceResult, chainResult, countResult, pendingResult, balanceResult, gasResult string
+	batch := []rpc.BatchElem{
+		{Method: "eth_maxPriorityFeePerGas", Params: []interface{}{}, Result: &tipResult},
+		{Method: "eth_gasPrice", Params: []interface{}{}, Result: &priceResult},
+		{Method: "eth_chainId", Params: []interface{}{}, Result: &chainResult},
+		{Method: "eth_getTransactionCount", Params: []interface{}{from.Hex(), tag}, Result: &countResult},
+		{Method: "eth_getTransactionCount", Params: []interface{}{from.Hex(), Pending}, Result: &pendingResult},
+		{Method: "eth_getBalance", Params: StringsToInterfaces([]string{from.Hex(), "latest"}), Result: &balanceResult},
+		{Method: "eth_estimateGas", Params: []interface{}{NewEstimateGasParam(from, to, value, data), tag}, Result: &gasResult},
+	}
 
 	// trigger the rpc
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		gasTip, err = MaxPriorityFeePerGas(term, endpoint)
-		if err != nil {
-			var e *rpc.RpcError
-			if errors.As(err, &e) && strings.Contains(e.Message, "does not exist") {
-				gasTip = nil
-				return
-			}
-			errs <- fmt.Errorf("failed to retrieve maxPriorityFeePerGas: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		gasPrice, err = GasPrice(term, endpoint)
-		if err != nil {
-			errs <- fmt.Errorf("failed to retrieve gasPrice: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		chainId, err = ChainId(term, endpoint)
-		if err != nil {
-			errs <- fmt.Errorf("failed to retrieve chainId: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		txCount, err = TransactionsCount(term, endpoint, from, tag)
-		if err != nil {
-			errs <- fmt.Errorf("failed to retrieve transaction count: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		txCountPending, err = TransactionsCount(term, endpoint, from, Pending)
-		if err != nil {
-			errs <- fmt.Errorf("failed to retrieve transaction count: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		fromBalance, err = GetAccountBalance(term, endpoint, from)
-		if err != nil {
-			errs <- fmt.Errorf("failed to retrieve account balance: %w", err)
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var err error
-		gas, err = EstimateGas(term, endpoint, from, to, value, data, tag)
-		if err != nil {
-			errs <- fmt.Errorf("failed to estimate gas: %w", err)
-		}
-	}()
-	wg.Wait()
+	client := httpclient.NewDefault(term)
+	err := rpc.BatchCallContext(ctx, term, client, endpoint, batch)
+	if err != nil {
+		return nil, err
+	}
 
-	// check errors
-	var err error
-	select {
-	case err = <-errs:
-	default:
+	// check errors and decode results
+	var gasTip *uint256.Int
+	if err := batch[0].Error; err != nil {
+		if !errors.Is(err, rpc.ErrMethodNotFound) {
+			return nil, fmt.Errorf("failed to retrieve maxPriorityFeePerGas: %w", err)
+		}
+	} else if gasTip, err = uint256.FromHex(tipResult); err != nil {
+		return nil, fmt.Errorf("failed to retrieve maxPriorityFeePerGas: %w", err)
+	}
+	if err := batch[1].Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve gasPrice: %w", err)
 	}
+	gasPrice, err := uint256.FromHex(priceResult)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to retrieve gasPrice: %w", err)
+	}
+	if err := batch[2].Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve chainId: %w", err)
+	}
+	chainId, err := uint256.FromHex(chainResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chainId: %w", err)
+	}
+	if err := batch[3].Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction count: %w", err)
+	}
+	txCount, err := HexToUint64(countResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction count: %w", err)
+	}
+	if err := batch[4].Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction count: %w", err)
+	}
+	txCountPending, err := HexToUint64(pendingResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction count: %w", err)
+	}
+	if err := batch[5].Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve account balance: %w", err)
+	}
+	fromBalance, err := uint256.FromHex(balanceResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve account balance: %w", err)
+	}
+	if err := batch[6].Error; err != nil {
+		return nil, fmt.Errorf("failed to estimate gas: %w", err)
+	}
+	gas, err := HexToUint64(gasResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate gas: %w", err)
 	}
 	return &TransactionParams{
 		Endpoint:       endpoint,
@@ -315,29 +299,3 @@ func GetTransactionParams(term ui.Screen, endpoint rpc.Endpoint, from common.Add
 		Balance:        fromBalance,
 	}, nil
 }
-
-func abiPackedValuesFromCli(ctx *cli.Context, typeNames []string) (abi.Arguments, []byte, error) {
-	argTypes, err := abi.TypesFromStrings(typeNames)
-	if err != nil {
-		return nil, nil, err
-	}
-	argValues, err := ValuesFromCli(ctx, argTypes)
-	if err != nil {
-		return nil, nil, err
-	}
-	packedValues, err := abi.PackValues(argTypes, argValues)
-	return argTypes, packedValues, err
-}
-
-func ValuesFromCli(ctx *cli.Context, inputs abi.Arguments) ([]string, error) {
-	values := []string{}
-	for i := range inputs {
-		argNum := strconv.FormatInt(int64(i), 10)
-		if !ctx.IsSet(argNum) {
-			return nil, fmt.Errorf("argument --%s not set", argNum)
-		}
-		arg := ctx.String(argNum)
-		values = append(values, arg)
-	}
-	return values, nil
-}
@@ -16,11 +16,7 @@ import (
 	"github.com/urfave/cli"
 )
 
-type RpcResultTxReceipt struct {
-	rpc.RpcResultStr
-	Result *TxReceipt `json:"result"`
-}
-
+// TxReceipt is the output of receipt, the fields are as returned by eth_getTransactionReceipt
 type TxReceipt struct {
 	BlockHash         string      `json:"blockHash"`
 	BlockNumber       string      `json:"blockNumber"`
@@ -38,7 +34,7 @@ type TxReceipt struct {
 	Type              string      `json:"type"`
 }
 
-func GetTransactionReceiptCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+func GetTransactionReceiptCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate args
 	if !ctx.IsSet(flags.HexParam.Name) {
 		return errors.New(fmt.Sprintf("Missing tx hash --%s", flags.HexParam.Name))
@@ -49,42 +45,107 @@ func GetTransactionReceiptCommand(term ui.Screen, ctx *cli.Context, endpoint rpc
 	}
 
 	// call
-	receipt, err := GetTransactionReceipt(term, endpoint, input)
+	receipt, err := GetTransactionReceiptContext(c, term, endpoint, input)
 	if err != nil {
 		return err
 	}
-	term.Output(fmt.Sprintf("%+v\n", receipt))
-	return nil
+	return term.Render(receipt)
 }
 
-// returns tx receipt
+// returns tx receipt or nil when the tx is not mined yet
 func GetTransactionReceipt(term ui.Screen, endpoint rpc.Endpoint, txHash string) (*TxReceipt, error) {
+	return GetTransactionReceiptContext(context.Background(), term, endpoint, txHash)
+}
+
+func GetTransactionReceiptContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, txHash string) (*TxReceipt, error) {
 	client := httpclient.NewDefault(term)
-	resp := RpcResultTxReceipt{}
-	err := rpc.Call(term, client, endpoint, "eth_getTransactionReceipt", StringsToInterfaces([]string{txHash}), &resp)
+	receipt := &TxReceipt{}
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_getTransactionReceipt", StringsToInterfaces([]string{txHash}), receipt)
+	if errors.Is(err, rpc.ErrNullResult) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	return resp.Result, nil
+	return receipt, nil
 }
 
+// WaitTransactionReceipt checks for the receipt on every new head when the endpoint supports
+// subscriptions and polls every second otherwise
 func WaitTransactionReceipt(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, txHash string) (*TxReceipt, error) {
+	if rpc.IsSubscriber(endpoint) {
+		heads := make(chan *BlockHeader, 16)
+		sub, err := SubscribeNewHeads(ctx, term, endpoint, heads)
+		if err == nil {
+			defer sub.Unsubscribe()
+			return waitTransactionReceiptOnHeads(ctx, term, endpoint, txHash, sub, heads)
+		}
+		term.Logf("failed to subscribe to new heads, polling instead: %v\n", err)
+	}
+	return pollTransactionReceipt(ctx, term, endpoint, txHash)
+}
+
+func waitTransactionReceiptOnHeads(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, txHash string, sub *rpc.Subscription, heads <-chan *BlockHeader) (*TxReceipt, error) {
+	logEvery := time.NewTicker(5 * time.Second)
+	defer logEvery.Stop()
+
+	var blockNumber *uint256.Int
+	for {
+		receipt, err := GetTransactionReceiptContext(ctx, term, endpoint, txHash)
+		if err != nil {
+			return nil, err
+		}
+		if receipt != nil {
+			return receipt, nil
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-sub.Done():
+				return nil, sub.Err()
+			case <-logEvery.C:
+				if blockNumber != nil {
+					term.Print(fmt.Sprintf("block number: %s", blockNumber))
+				}
+			case head := <-heads:
+				blockNumber, _ = uint256.FromHex(head.Number)
+				break wait
+			}
+		}
+	}
+}
+
+func pollTransactionReceipt(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, txHash string) (*TxReceipt, error) {
 	waitTicker := time.NewTicker(time.Second)
 	defer waitTicker.Stop()
 
 	logEvery := time.NewTicker(5 * time.Second)
 	defer logEvery.Stop()
 
+	client := httpclient.NewDefault(term)
 	var blockNumber *uint256.Int
 	for {
-		receipt, err := GetTransactionReceipt(term, endpoint, txHash)
+		receipt := &TxReceipt{}
+		var block string
+		batch := []rpc.BatchElem{
+			{Method: "eth_getTransactionReceipt", Params: StringsToInterfaces([]string{txHash}), Result: receipt},
+			{Method: "eth_blockNumber", Params: []interface{}{}, Result: &block},
+		}
+		err := rpc.BatchCallContext(ctx, term, client, endpoint, batch)
 		if err != nil {
 			return nil, err
 		}
-		if receipt != nil {
+		if batch[0].Error == nil {
 			return receipt, nil
 		}
-		blockNumber, _ = BlockNumber(term, endpoint)
+		if !errors.Is(batch[0].Error, rpc.ErrNullResult) {
+			return nil, batch[0].Error
+		}
+		if batch[1].Error == nil {
+			blockNumber, _ = uint256.FromHex(block)
+		}
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
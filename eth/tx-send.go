@@ -17,13 +17,17 @@ import (
 	"github.com/urfave/cli"
 )
 
-func SendTransactionCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+// SendTransactionOutput is the output of tx-send, its text format is the hash
+type SendTransactionOutput struct {
+	Hash    string     `json:"hash"`
+	Receipt *TxReceipt `json:"receipt"`
+}
+
+func SendTransactionCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
 	// validate input
 	var rawTxStr string
 	if ctx.IsSet(flags.TxParam.Name) {
 		rawTxStr = ctx.String(flags.TxParam.Name)
-	} else if flags.FlagRawTx != nil && *flags.FlagRawTx != "" {
-		rawTxStr = *flags.FlagRawTx
 	} else {
 		return errors.New(fmt.Sprintf("Missing signed tx in --%s", flags.TxParam.Name))
 	}
@@ -36,42 +40,53 @@ func SendTransactionCommand(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpo
 		return err
 	}
 	// compare that provided tx chain id is same as endpoint chain id
-	endpointChainId, err := ChainId(term, endpoint)
+	endpointChainId, err := ChainIdContext(c, term, endpoint)
 	if err != nil {
 		return err
 	}
 	if tx.GetChainID().Cmp(endpointChainId) != 0 {
 		return errors.New(fmt.Sprintf("endpoint chain-id: %v not same as tx chain-id: %v", endpointChainId, tx.GetChainID()))
 	}
-	term.Print(fmt.Sprintf("Sending tx to: %s (nonce: %d, gas: %d)", endpoint.Url(), tx.GetNonce(), tx.GetGas()))
+	if network := NetworkFromContext(c); network != nil {
+		// the fee cap is the most the tx can pay per gas
+		if err := CheckMaxGasPrice(network.Fees, tx.GetFeeCap()); err != nil {
+			return err
+		}
+	}
+	term.Print(fmt.Sprintf("Sending tx to: %s (nonce: %d, gas: %d)", rpc.RedactUrl(endpoint.Url()), tx.GetNonce(), tx.GetGas()))
 	term.Logf("gas: %v\n", tx.GetGas())
 	term.Logf("gasPrice: %v\n", tx.GetPrice())
 
 	// send tx
-	hash, err := SendTransaction(term, endpoint, rawTx)
+	hash, err := SendTransactionContext(c, term, endpoint, rawTx)
 	if err != nil {
 		return err
 	}
 	term.Print(fmt.Sprintf("Sent tx. Hash: %s Waiting for confirmation...", hash))
 
 	// wait for tx receipt
-	c, _ := context.WithTimeout(context.Background(), 120*time.Second)
-	receipt, err := WaitTransactionReceipt(c, term, endpoint, hash)
+	waitCtx, cancel := context.WithTimeout(c, 120*time.Second)
+	defer cancel()
+	receipt, err := WaitTransactionReceipt(waitCtx, term, endpoint, hash)
 	if err != nil {
 		return err
 	}
-	term.Print(fmt.Sprintf("Received receipt: %+v", receipt))
-	return nil
+	term.Print(fmt.Sprintf("Received receipt. Block: %s Status: %s", receipt.BlockNumber, receipt.Status))
+	return term.Render(ui.WithText(SendTransactionOutput{Hash: hash, Receipt: receipt}, hash))
 }
 
 // returns tx hash
 func SendTransaction(term ui.Screen, endpoint rpc.Endpoint, rawSignedTx []byte) (string, error) {
+	return SendTransactionContext(context.Background(), term, endpoint, rawSignedTx)
+}
+
+func SendTransactionContext(ctx context.Context, term ui.Screen, endpoint rpc.Endpoint, rawSignedTx []byte) (string, error) {
 	tx := hexutil.Encode(rawSignedTx)
 	client := httpclient.NewDefault(term)
-	resp := rpc.RpcResultStr{}
-	err := rpc.Call(term, client, endpoint, "eth_sendRawTransaction", StringsToInterfaces([]string{tx}), &resp)
+	var result string
+	err := rpc.CallResultContext(ctx, term, client, endpoint, "eth_sendRawTransaction", StringsToInterfaces([]string{tx}), &result)
 	if err != nil {
 		return "", err
 	}
-	return resp.Result, nil
+	return result, nil
 }
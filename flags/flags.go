@@ -1,129 +1,276 @@
 package flags
 
-import "github.com/urfave/cli"
+import (
+	"time"
 
-var (
-	FlagRpcUrl *string
-	FlagRawTx  *string
+	"github.com/urfave/cli"
 )
 
 var (
-	RpcUrl = cli.StringFlag{
-		Name:        "rpc.url",
-		Usage:       "Rpc endpoint url",
-		Destination: FlagRpcUrl,
+	RpcUrl = cli.StringSliceFlag{
+		Name:   "rpc.url",
+		EnvVar: "JETH_RPC_URL",
+		Usage:  "Rpc endpoint url (http(s)://, ws(s):// or an ipc socket path), repeat it or separate with commas to fail over between endpoints",
+	}
+	RpcGroup = cli.StringFlag{
+		Name:   "rpc.group",
+		EnvVar: "JETH_RPC_GROUP",
+		Usage:  "Name of an endpoint group in the config file to use instead of --rpc.url",
+	}
+	Network = cli.StringFlag{
+		Name:   "network",
+		EnvVar: "JETH_NETWORK",
+		Usage:  "Use the endpoints and defaults of a network in the config file, its chain id is checked before sending transactions",
+	}
+	RpcHeader = cli.StringSliceFlag{
+		Name:   "rpc.header",
+		EnvVar: "JETH_RPC_HEADER",
+		Usage:  "Header sent with every rpc request, as \"Name: value\", can be repeated",
+	}
+	JwtSecret = cli.StringFlag{
+		Name:   "rpc.jwt-secret",
+		EnvVar: "JETH_RPC_JWT_SECRET",
+		Usage:  "Path to a hex encoded secret file used to sign jwt bearer tokens (authenticated erigon/geth ports)",
+	}
+	RpcRecord = cli.StringFlag{
+		Name:   "rpc.record",
+		EnvVar: "JETH_RPC_RECORD",
+		Usage:  "Record rpc requests and responses to a cassette file",
+	}
+	RpcReplay = cli.StringFlag{
+		Name:   "rpc.replay",
+		EnvVar: "JETH_RPC_REPLAY",
+		Usage:  "Serve rpc responses from a cassette file recorded with --rpc.record instead of a node",
+	}
+	RpcCache = cli.StringFlag{
+		Name:   "rpc.cache",
+		EnvVar: "JETH_RPC_CACHE",
+		Usage:  "Cache responses that cannot change: \"memory\", \"disk\" for the user cache dir, or a cache directory",
+	}
+	RpcRateLimit = cli.Float64Flag{
+		Name:   "rpc.rate-limit",
+		EnvVar: "JETH_RPC_RATE_LIMIT",
+		Usage:  "Max rpc requests per second to each endpoint, a batch counts each call",
+	}
+	RpcMaxConcurrent = cli.IntFlag{
+		Name:   "rpc.max-concurrent",
+		EnvVar: "JETH_RPC_MAX_CONCURRENT",
+		Usage:  "Max rpc requests in flight to each endpoint",
+	}
+	RpcMaxIdleConns = cli.IntFlag{
+		Name:   "rpc.max-idle-conns",
+		EnvVar: "JETH_RPC_MAX_IDLE_CONNS",
+		Usage:  "Max keep-alive connections kept open to each endpoint",
+	}
+	RpcCaFile = cli.StringFlag{
+		Name:   "rpc.ca-file",
+		EnvVar: "JETH_RPC_CA_FILE",
+		Usage:  "Trust the certificate authorities in this pem file besides the system ones",
+	}
+	RpcCert = cli.StringFlag{
+		Name:   "rpc.cert",
+		EnvVar: "JETH_RPC_CERT",
+		Usage:  "Client certificate pem file for endpoints that require mutual tls, used with --rpc.key",
+	}
+	RpcKey = cli.StringFlag{
+		Name:   "rpc.key",
+		EnvVar: "JETH_RPC_KEY",
+		Usage:  "Private key pem file of --rpc.cert",
+	}
+	RpcInsecure = cli.BoolFlag{
+		Name:   "rpc.insecure",
+		EnvVar: "JETH_RPC_INSECURE",
+		Usage:  "Accept any server certificate. Only for nodes without a valid one, anyone in between can read and change the traffic",
+	}
+	RpcProxy = cli.StringFlag{
+		Name:   "rpc.proxy",
+		EnvVar: "JETH_RPC_PROXY",
+		Usage:  "Connect through this http, https or socks5 proxy url instead of the one in HTTPS_PROXY",
+	}
+	RpcDialTimeout = cli.DurationFlag{
+		Name:   "rpc.dial-timeout",
+		EnvVar: "JETH_RPC_DIAL_TIMEOUT",
+		Usage:  "Max time to connect to an endpoint, including the tls handshake",
+		Value:  30 * time.Second,
+	}
+	RpcTimeout = cli.DurationFlag{
+		Name:   "rpc.timeout",
+		EnvVar: "JETH_RPC_TIMEOUT",
+		Usage:  "Max time for each request to an endpoint",
+		Value:  60 * time.Second,
+	}
+	RpcGzipMinSize = cli.IntFlag{
+		Name:   "rpc.gzip-min-size",
+		EnvVar: "JETH_RPC_GZIP_MIN_SIZE",
+		Usage:  "Gzip request bodies of at least this many bytes, for endpoints that accept it. Responses are always negotiated",
+	}
+	RetryAttempts = cli.IntFlag{
+		Name:   "retry.attempts",
+		EnvVar: "JETH_RETRY_ATTEMPTS",
+		Usage:  "Attempts per http request, including the first",
+		Value:  3,
+	}
+	RetryBase = cli.DurationFlag{
+		Name:   "retry.base",
+		EnvVar: "JETH_RETRY_BASE",
+		Usage:  "Wait before the first retry, doubled for each further retry with random jitter",
+		Value:  500 * time.Millisecond,
+	}
+	RetryMaxDelay = cli.DurationFlag{
+		Name:   "retry.max-delay",
+		EnvVar: "JETH_RETRY_MAX_DELAY",
+		Usage:  "Max wait between retries, a longer Retry-After header is capped at 2 minutes",
+		Value:  30 * time.Second,
+	}
+	RetryBudget = cli.IntFlag{
+		Name:   "retry.budget",
+		EnvVar: "JETH_RETRY_BUDGET",
+		Usage:  "Max retries of all requests of a command, 0 for no limit",
+	}
+	RpcConsensus = cli.IntFlag{
+		Name:   "rpc.consensus",
+		EnvVar: "JETH_RPC_CONSENSUS",
+		Usage:  "Send each request to all endpoints and require this many to agree on the answer, 0 for a majority",
+	}
+	RoundRobin = cli.BoolFlag{
+		Name:   "rpc.round-robin",
+		EnvVar: "JETH_RPC_ROUND_ROBIN",
+		Usage:  "spread read requests over all healthy endpoints",
+	}
+	MetricsFile = cli.StringFlag{
+		Name:   "metrics.file",
+		EnvVar: "JETH_METRICS_FILE",
+		Usage:  "Write rpc metrics in the prometheus text format to this file when done, e.g. for the node_exporter textfile collector",
+	}
+	ListenAddr = cli.StringFlag{
+		Name:   "listen",
+		EnvVar: "JETH_LISTEN",
+		Usage:  "Address the proxy listens on",
+		Value:  "127.0.0.1:8645",
+	}
+	AllowMethods = cli.StringSliceFlag{
+		Name:   "allow",
+		EnvVar: "JETH_ALLOW",
+		Usage:  "Methods the proxy passes on, all when not given. Can be repeated or comma separated",
+	}
+	DenyMethods = cli.StringSliceFlag{
+		Name:   "deny",
+		EnvVar: "JETH_DENY",
+		Usage:  "Methods the proxy refuses. Can be repeated or comma separated",
+	}
+	ReadOnly = cli.BoolFlag{
+		Name:   "read-only",
+		EnvVar: "JETH_READ_ONLY",
+		Usage:  "Pass only methods that read state, e.g. eth_call, and refuse the others like eth_sendRawTransaction, personal_* or admin_*",
+	}
+	LogFile = cli.StringFlag{
+		Name:   "log-file",
+		EnvVar: "JETH_LOG_FILE",
+		Usage:  "Append every proxied call to this file as a json line",
 	}
 	Verbose = cli.BoolFlag{
-		Name:  "verbose",
-		Usage: "output debug information",
+		Name:   "verbose",
+		EnvVar: "JETH_VERBOSE",
+		Usage:  "output debug information",
 	}
 	StdIn = cli.BoolFlag{
-		Name:  "stdin",
-		Usage: "read input from standard input",
+		Name:   "stdin",
+		EnvVar: "JETH_STDIN",
+		Usage:  "read a json object with flag values from standard input, it is read without the flag when stdin is a pipe or a file",
+	}
+	Json = cli.BoolFlag{
+		Name:   "json",
+		EnvVar: "JETH_JSON",
+		Usage:  "output a json object, like --output=json",
+	}
+	Output = cli.StringFlag{
+		Name:   "output",
+		EnvVar: "JETH_OUTPUT",
+		Usage:  "Output format, one of json, text, table, csv or yaml (default: json for tx-params, call and pack-values, text for the others)",
 	}
 	Gwei = cli.BoolFlag{
-		Name:  "gwei",
-		Usage: "output in gwei's",
+		Name:   "gwei",
+		EnvVar: "JETH_GWEI",
+		Usage:  "output in gwei's",
 	}
 	Plain = cli.BoolFlag{
-		Name:  "plain",
-		Usage: "output as plain text",
+		Name:   "plain",
+		EnvVar: "JETH_PLAIN",
+		Usage:  "output as plain text, like --output=text",
 	}
 	HexParam = cli.StringFlag{
-		Name:  "param",
-		Usage: "provide rpc param in hex format (starts with 0x)",
+		Name:   "param",
+		EnvVar: "JETH_PARAM",
+		Usage:  "provide rpc param in hex format (starts with 0x)",
 	}
 	TxParam = cli.StringFlag{
-		Name:        "tx",
-		Usage:       "provide a raw tx in hex format (starts with 0x)",
-		Destination: FlagRawTx,
+		Name:   "tx",
+		EnvVar: "JETH_TX",
+		Usage:  "provide a raw tx in hex format (starts with 0x)",
 	}
 	FromParam = cli.StringFlag{
-		Name:  "from",
-		Usage: "provide from address in hex format (starts with 0x)",
+		Name:   "from",
+		EnvVar: "JETH_FROM",
+		Usage:  "provide from address in hex format (starts with 0x)",
 	}
 	ToParam = cli.StringFlag{
-		Name:  "to",
-		Usage: "provide to address in hex format (starts with 0x)",
+		Name:   "to",
+		EnvVar: "JETH_TO",
+		Usage:  "provide to address in hex format (starts with 0x)",
 	}
 	ValueParam = cli.StringFlag{
-		Name:  "value",
-		Usage: "in wei",
+		Name:   "value",
+		EnvVar: "JETH_VALUE",
+		Usage:  "in wei",
 	}
 	ValueInEthParam = cli.BoolFlag{
-		Name:  "value-eth",
-		Usage: "indicate that provided --value is in eth and not in wei",
+		Name:   "value-eth",
+		EnvVar: "JETH_VALUE_ETH",
+		Usage:  "indicate that provided --value is in eth and not in wei",
 	}
 	ValueInGweiParam = cli.BoolFlag{
-		Name:  "value-gwei",
-		Usage: "indicate that provided --value is in gwei and not in wei",
+		Name:   "value-gwei",
+		EnvVar: "JETH_VALUE_GWEI",
+		Usage:  "indicate that provided --value is in gwei and not in wei",
 	}
 	DataParam = cli.StringFlag{
-		Name:  "data",
-		Usage: "A hexadecimal data for tx",
+		Name:   "data",
+		EnvVar: "JETH_DATA",
+		Usage:  "A hexadecimal data for tx",
 	}
 	DeployParam = cli.StringFlag{
-		Name:  "deploy",
-		Usage: "Provide constructor params",
+		Name:   "deploy",
+		EnvVar: "JETH_DEPLOY",
+		Usage:  "Provide constructor param types, example: --deploy=address,uint256",
 	}
 	BinParam = cli.StringFlag{
-		Name:  "bin",
-		Usage: "Binary data in hex",
+		Name:   "bin",
+		EnvVar: "JETH_BIN",
+		Usage:  "Binary data in hex",
 	}
 	BinFileParam = cli.StringFlag{
-		Name:  "bin-file",
-		Usage: "Binary data in hex from file",
+		Name:   "bin-file",
+		EnvVar: "JETH_BIN_FILE",
+		Usage:  "Binary data in hex from file",
 	}
 	MethodParam = cli.StringFlag{
-		Name:  "method",
-		Usage: "A method call with params",
+		Name:   "method",
+		EnvVar: "JETH_METHOD",
+		Usage:  "A method to call, example: --method=transfer(address,uint256), or give it as the first argument",
 	}
 	OutputTypesParam = cli.StringFlag{
-		Name:  "out",
-		Usage: "Output types, example: --out=uint256,address",
-	}
-	Param0 = cli.StringFlag{
-		Name:  "0",
-		Usage: "",
-	}
-	Param1 = cli.StringFlag{
-		Name:  "1",
-		Usage: "",
-	}
-	Param2 = cli.StringFlag{
-		Name:  "2",
-		Usage: "",
-	}
-	Param3 = cli.StringFlag{
-		Name:  "3",
-		Usage: "",
-	}
-	Param4 = cli.StringFlag{
-		Name:  "4",
-		Usage: "",
-	}
-	Param5 = cli.StringFlag{
-		Name:  "5",
-		Usage: "",
-	}
-	Param6 = cli.StringFlag{
-		Name:  "6",
-		Usage: "",
-	}
-	Param7 = cli.StringFlag{
-		Name:  "7",
-		Usage: "",
-	}
-	Param8 = cli.StringFlag{
-		Name:  "8",
-		Usage: "",
+		Name:   "out",
+		EnvVar: "JETH_OUT",
+		Usage:  "Output types, example: --out=uint256,address",
 	}
-	Param9 = cli.StringFlag{
-		Name:  "9",
-		Usage: "",
+	ArgParam = cli.StringSliceFlag{
+		Name:   "arg",
+		EnvVar: "JETH_ARG",
+		Usage:  "A method or constructor argument, repeat it for every argument or give them after the method. Give negative numbers as --arg=-5, cli takes -5 alone for a flag",
 	}
 	NoTip = cli.BoolFlag{
-		Name:  "no-tip",
-		Usage: "output no gasTip param",
+		Name:   "no-tip",
+		EnvVar: "JETH_NO_TIP",
+		Usage:  "output no gasTip param",
 	}
 )
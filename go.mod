@@ -3,21 +3,21 @@ module github.com/jaanek/jeth
 go 1.17
 
 require (
+	github.com/VictoriaMetrics/metrics v1.18.0
 	github.com/holiman/uint256 v1.2.0
+	github.com/ledgerwatch/erigon v1.9.7-0.20210917090023-5e4bd653d736
 	github.com/pkg/errors v0.9.1
 	github.com/urfave/cli v1.22.5
 	golang.org/x/crypto v0.0.0-20210915214749-c084706c2272
 )
 
 require (
-	github.com/VictoriaMetrics/metrics v1.18.0 // indirect
 	github.com/btcsuite/btcd v0.22.0-beta // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
 	github.com/fjl/gencodec v0.0.0-20191126094850-e283372f291f // indirect
 	github.com/garslo/gogen v0.0.0-20170307003452-d6ebae628c7c // indirect
 	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/kevinburke/go-bindata v3.22.0+incompatible // indirect
-	github.com/ledgerwatch/erigon v1.9.7-0.20210917090023-5e4bd653d736 // indirect
 	github.com/ledgerwatch/erigon-lib v0.0.0-20210917092859-d32bc94cf8c6 // indirect
 	github.com/ledgerwatch/log/v3 v3.3.0 // indirect
 	github.com/ledgerwatch/secp256k1 v0.0.0-20210626115225-cd5cd00ed72d // indirect
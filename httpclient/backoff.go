@@ -0,0 +1,126 @@
+package httpclient
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of retry and rate limit waits, so they can be driven by a fake
+// clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+var SystemClock Clock = systemClock{}
+
+// DefaultClock is used by the clients and limiters created after it is set
+var DefaultClock = SystemClock
+
+// Backoff waits exponentially longer between retries with full jitter: the wait before
+// retry n is random between zero and Base*2^(n-1), capped at Max. When a 429 or 503
+// response has a Retry-After header, its delay is used instead.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+	// MaxRetryAfter caps how long a Retry-After header makes us wait, zero means no cap
+	MaxRetryAfter time.Duration
+	// Rand returns a random number in [0, n), rand.Int63n when nil
+	Rand func(n int64) int64
+	// Clock tells the time a Retry-After date is counted from, DefaultClock when nil
+	Clock Clock
+}
+
+// DefaultBackoff is the wait delay of the clients created after it is set
+var DefaultBackoff = Backoff{
+	Base:          500 * time.Millisecond,
+	Max:           30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// Delay returns the wait before the retry that follows attempt attemptNum
+func (b Backoff) Delay(attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		clock := b.Clock
+		if clock == nil {
+			clock = DefaultClock
+		}
+		if retryAfter, ok := RetryAfter(resp, clock); ok {
+			if b.MaxRetryAfter > 0 && retryAfter > b.MaxRetryAfter {
+				return b.MaxRetryAfter
+			}
+			return retryAfter
+		}
+	}
+	ceiling := b.Base
+	for i := 1; i < attemptNum && (b.Max <= 0 || ceiling < b.Max) && ceiling < math.MaxInt64/2; i++ {
+		ceiling *= 2
+	}
+	if b.Max > 0 && ceiling > b.Max {
+		ceiling = b.Max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	random := b.Rand
+	if random == nil {
+		random = rand.Int63n
+	}
+	return time.Duration(random(int64(ceiling) + 1))
+}
+
+// WithBackoff returns a wait delay using b
+func WithBackoff(b Backoff) RetryWaitDelay {
+	return b.Delay
+}
+
+// RetryBudget limits the retries of all requests sharing it, e.g. the requests of a
+// command, so a failing endpoint cannot keep it busy retrying. A nil budget is unlimited.
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+func NewRetryBudget(retries int) *RetryBudget {
+	return &RetryBudget{remaining: retries}
+}
+
+// DefaultRetryBudget is shared by the clients created after it is set, nil for no limit
+var DefaultRetryBudget *RetryBudget
+
+// Take uses up a retry, it returns false when none are left
+func (b *RetryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// Remaining returns how many retries are left, -1 for an unlimited budget
+func (b *RetryBudget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining
+}
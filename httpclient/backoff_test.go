@@ -0,0 +1,175 @@
+package httpclient_test
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// largest makes the backoff wait its full ceiling
+func largest(n int64) int64 {
+	return n - 1
+}
+
+func TestBackoffGrowth(t *testing.T) {
+	b := httpclient.Backoff{Base: 100 * time.Millisecond, Max: time.Second, Rand: largest}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := b.Delay(i+1, nil); got != w*time.Millisecond {
+			t.Errorf("attempt %d: waits %s, want %s", i+1, got, w*time.Millisecond)
+		}
+	}
+	// many attempts must not overflow
+	if got := b.Delay(1000, nil); got != time.Second {
+		t.Errorf("attempt 1000: waits %s, want %s", got, time.Second)
+	}
+}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	b := httpclient.Backoff{Base: 100 * time.Millisecond, Max: time.Second, Rand: rand.New(rand.NewSource(1)).Int63n}
+	for attempt := 1; attempt <= 6; attempt++ {
+		ceiling := httpclient.Backoff{Base: b.Base, Max: b.Max, Rand: largest}.Delay(attempt, nil)
+		for i := 0; i < 1000; i++ {
+			if d := b.Delay(attempt, nil); d < 0 || d > ceiling {
+				t.Fatalf("attempt %d: waits %s, want within [0, %s]", attempt, d, ceiling)
+			}
+		}
+	}
+	if d := (httpclient.Backoff{Base: time.Second, Rand: func(int64) int64 { return 0 }}).Delay(3, nil); d != 0 {
+		t.Errorf("full jitter can wait 0, got %s", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	clock := rpctest.NewClock()
+	b := httpclient.Backoff{Base: time.Millisecond, MaxRetryAfter: time.Minute, Rand: largest, Clock: clock}
+	response := func(status int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		resp.Header.Set("Retry-After", retryAfter)
+		return resp
+	}
+	tests := []struct {
+		name string
+		resp *http.Response
+		want time.Duration
+	}{
+		{"seconds", response(http.StatusTooManyRequests, "7"), 7 * time.Second},
+		{"date", response(http.StatusServiceUnavailable, clock.Now().Add(30*time.Second).Format(http.TimeFormat)), 30 * time.Second},
+		{"date in the past", response(http.StatusServiceUnavailable, clock.Now().Add(-time.Hour).Format(http.TimeFormat)), 0},
+		{"capped", response(http.StatusTooManyRequests, "3600"), time.Minute},
+		{"ignored on other statuses", response(http.StatusInternalServerError, "7"), time.Millisecond},
+		{"invalid", response(http.StatusTooManyRequests, "soon"), time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := b.Delay(1, test.resp); got != test.want {
+			t.Errorf("%s: waits %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// withDefaults sets the package defaults for the clients created in a test
+func withDefaults(t *testing.T, clock httpclient.Clock, backoff httpclient.Backoff, budget *httpclient.RetryBudget) {
+	oldClock, oldBackoff, oldBudget := httpclient.DefaultClock, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget
+	httpclient.DefaultClock, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget = clock, backoff, budget
+	t.Cleanup(func() {
+		httpclient.DefaultClock, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget = oldClock, oldBackoff, oldBudget
+	})
+}
+
+// failingServer answers with the statuses in order and then with 200
+func failingServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n <= len(statuses) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// get runs a request in the background while the test moves the clock
+func get(client httpclient.HttpClient, url string) chan error {
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	return done
+}
+
+func TestClientBacksOffWithClock(t *testing.T) {
+	clock := rpctest.NewClock()
+	withDefaults(t, clock, httpclient.Backoff{Base: time.Second, Max: time.Minute, Rand: largest}, nil)
+	server, requests := failingServer(t, nil, http.StatusBadGateway, http.StatusBadGateway)
+	client := httpclient.New(rpctest.NewScreen(""), 3)
+
+	done := get(client, server.URL)
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second} {
+		clock.BlockUntil(1)
+		clock.Advance(wait)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	waits := clock.Waits()
+	if len(waits) != 2 || waits[0] != time.Second || waits[1] != 2*time.Second {
+		t.Errorf("waited %v, want [1s 2s]", waits)
+	}
+}
+
+func TestClientWaitsRetryAfter(t *testing.T) {
+	clock := rpctest.NewClock()
+	withDefaults(t, clock, httpclient.Backoff{Base: time.Second, Rand: largest}, nil)
+	server, _ := failingServer(t, http.Header{"Retry-After": {"7"}}, http.StatusTooManyRequests)
+	client := httpclient.New(rpctest.NewScreen(""), 3)
+
+	done := get(client, server.URL)
+	clock.BlockUntil(1)
+	clock.Advance(7 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if waits := clock.Waits(); len(waits) != 1 || waits[0] != 7*time.Second {
+		t.Errorf("waited %v, want [7s]", waits)
+	}
+}
+
+func TestClientStopsWhenBudgetIsUsedUp(t *testing.T) {
+	clock := rpctest.NewClock()
+	budget := httpclient.NewRetryBudget(1)
+	withDefaults(t, clock, httpclient.Backoff{}, budget)
+	server, requests := failingServer(t, nil, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	client := httpclient.New(rpctest.NewScreen(""), 5)
+
+	_, err := client.Get(server.URL)
+	var statusErr *httpclient.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("got %v, want a status error", err)
+	}
+	if statusErr.Attempts != 2 || atomic.LoadInt32(requests) != 2 {
+		t.Errorf("%d attempts and %d requests, want 2", statusErr.Attempts, atomic.LoadInt32(requests))
+	}
+	if budget.Remaining() != 0 {
+		t.Errorf("%d retries left in the budget, want 0", budget.Remaining())
+	}
+}
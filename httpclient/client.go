@@ -1,16 +1,23 @@
 package httpclient
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/jaanek/jeth/metrics"
 	"github.com/jaanek/jeth/ui"
 )
 
-var DefaultRetryMax = 1
+// DefaultRetryMax is the number of attempts of a request, including the first
+var DefaultRetryMax = 3
+
+// DefaultTimeout limits each attempt of a request, including reading the response
+var DefaultTimeout = 60 * time.Second
 
 func NewDefault(ui ui.Screen) HttpClient {
 	return New(ui, DefaultRetryMax)
@@ -20,19 +27,34 @@ func New(ui ui.Screen, retryMax int) HttpClient {
 	return &httpClient{
 		ui: ui,
 		client: http.Client{
-			Timeout: time.Second * 1 * 60,
-			// Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			Timeout:   DefaultTimeout,
+			Transport: defaultTransport,
 		},
 		RetryMax:       retryMax,
 		RetryCheck:     WithDefaultRetryPolicy(),
 		RetryWaitDelay: WithDefaultRetryWaitDelay(),
+		RetryBudget:    DefaultRetryBudget,
+		Clock:          DefaultClock,
 	}
 }
 
 type HttpClient interface {
 	Post(url, contentType string, body io.ReadSeeker) (resp *http.Response, err error)
+	PostContext(ctx context.Context, url, contentType string, body io.ReadSeeker) (resp *http.Response, err error)
 	Get(url string) (resp *http.Response, err error)
+	GetContext(ctx context.Context, url string) (resp *http.Response, err error)
 	Do(req *Request) (*http.Response, error)
+	// Policy returns how the client retries requests, callers that retry on their own
+	// follow it too
+	Policy() RetryPolicy
+}
+
+// RetryPolicy is the number of attempts, wait between them and shared budget of retries
+type RetryPolicy struct {
+	RetryMax       int
+	RetryWaitDelay RetryWaitDelay
+	RetryBudget    *RetryBudget
+	Clock          Clock
 }
 
 type httpClient struct {
@@ -41,7 +63,9 @@ type httpClient struct {
 	RetryCheck     RetryCheck
 	RetryMax       int
 	RetryWaitDelay RetryWaitDelay
+	RetryBudget    *RetryBudget
 	ErrorHandler   ErrorHandler
+	Clock          Clock
 }
 
 type RetryCheck func(req *Request, resp *http.Response, err error) (bool, error)
@@ -64,52 +88,99 @@ func WithDefaultRetryPolicy() RetryCheck {
 	}
 }
 
-func WithDefaultRetryWaitDelay() RetryWaitDelay {
-	return func(attemptNum int, resp *http.Response) (waitDelay time.Duration) {
-		waitDelay = time.Second * 10
-
-		// on "net/http: TLS handshake timeout" the resp is nil
-		if resp == nil {
-			return
-		}
-
-		// Request Throttling - Too many requests
-		if resp.StatusCode == http.StatusTooManyRequests {
-			waitDelay = time.Minute * 1
-		} else if resp.StatusCode == http.StatusUnauthorized {
-			waitDelay = time.Second * 1
-		}
-		return
+// NoRetries sends a request once, e.g. one that must not be repeated blindly because it
+// might have been processed before the attempt failed
+func NoRetries() RetryCheck {
+	return func(req *Request, resp *http.Response, err error) (bool, error) {
+		return false, nil
 	}
 }
 
+// WithDefaultRetryWaitDelay backs off with DefaultBackoff
+func WithDefaultRetryWaitDelay() RetryWaitDelay {
+	return WithBackoff(DefaultBackoff)
+}
+
 // Request wraps the metadata needed to create HTTP requests.
 type Request struct {
 	body io.ReadSeeker
 	*http.Request
+	// Prepare is called before every attempt, e.g. to refresh auth headers
+	Prepare func(req *http.Request) error
+	// Cost is the number of rate limit tokens the request takes, e.g. the size of a batch
+	Cost int
+	// Transport replaces the transport of the client, e.g. with the connection pool of an
+	// endpoint
+	Transport http.RoundTripper
+	// Timeout replaces the timeout of each attempt when set
+	Timeout time.Duration
+	// RetryCheck replaces the retry check of the client when set
+	RetryCheck RetryCheck
+	// GzipMinSize gzips bodies of at least this many bytes, unless the host refused a
+	// gzipped request before. Zero sends them as they are.
+	GzipMinSize int
+}
+
+func (r *Request) setBody(body io.ReadSeeker, length int64) {
+	r.body = body
+	r.Request.Body = ioutil.NopCloser(body)
+	r.ContentLength = length
 }
 
 func NewRequest(method, url string, body io.ReadSeeker) (*Request, error) {
+	return NewRequestWithContext(context.Background(), method, url, body)
+}
+
+// NewRequestWithContext returns a request that is aborted, including any retry waits,
+// when ctx is done
+func NewRequestWithContext(ctx context.Context, method, url string, body io.ReadSeeker) (*Request, error) {
 	var rcBody io.ReadCloser
 	if body != nil {
 		rcBody = ioutil.NopCloser(body)
 	}
 
-	httpReq, err := http.NewRequest(method, url, rcBody)
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, rcBody)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Request{body, httpReq}, nil
+	return &Request{body: body, Request: httpReq}, nil
 }
 
 func (c *httpClient) Do(req *Request) (*http.Response, error) {
 	var resp *http.Response
 	var err error
-	defer c.client.CloseIdleConnections()
+	var lastBody []byte
+	var attempts int
+	client := c.client
+	if req.Transport != nil {
+		client.Transport = req.Transport
+	}
+	if req.Timeout > 0 {
+		client.Timeout = req.Timeout
+	}
+	// responses are decoded here, so the received size can be logged
+	negotiate := req.Header.Get("Accept-Encoding") == ""
+	if negotiate {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	plain, plainLength := req.body, req.ContentLength
+	gzipped := false
+	if req.GzipMinSize > 0 && req.body != nil && !refusesGzip(req.URL.Host) {
+		compressed, size, err := gzipBody(req.body, req.GzipMinSize)
+		if err != nil {
+			return nil, err
+		}
+		if compressed != nil {
+			c.ui.Logf("%s %s: sending %d bytes gzipped to %d\n", req.Method, req.URL.Redacted(), size, len(compressed))
+			req.setBody(bytes.NewReader(compressed), int64(len(compressed)))
+			req.Header.Set("Content-Encoding", "gzip")
+			gzipped = true
+		}
+	}
 
-	for i := 1; ; i++ {
-		c.ui.Logf("%s %s\n", req.Method, req.URL)
+	for attempts = 1; ; attempts++ {
+		c.ui.Logf("%s %s\n", req.Method, req.URL.Redacted())
 
 		// Always rewind the request body when non-nil
 		if req.body != nil {
@@ -118,72 +189,173 @@ func (c *httpClient) Do(req *Request) (*http.Response, error) {
 			}
 		}
 
+		// Wait for the rate limit of the endpoint
+		limiter := limiterFor(req.URL)
+		start := time.Now()
+		release, waitErr := limiter.Wait(req.Context(), req.Cost)
+		if waitErr != nil {
+			return nil, waitErr
+		}
+		if waited := time.Since(start); waited > 100*time.Millisecond {
+			c.ui.Logf("%s %s: waited %s for rate limit\n", req.Method, req.URL.Redacted(), waited.Round(time.Millisecond))
+		}
+		if req.Prepare != nil {
+			if err := req.Prepare(req.Request); err != nil {
+				release()
+				return nil, err
+			}
+		}
+
 		// Attempt the request
-		resp, err = c.client.Do(req.Request)
+		attemptStart := time.Now()
+		resp, err = client.Do(req.Request)
+		if resp != nil {
+			metrics.HttpAttempt(req.URL.Host, time.Since(attemptStart), resp.StatusCode)
+			if negotiate {
+				decodeBody(resp, func(encoding string, received, decoded int64) {
+					c.ui.Logf("%s %s: received %d bytes %s encoded, %d decoded\n", req.Method, req.URL.Redacted(), received, encoding, decoded)
+				})
+			}
+			resp.Body = &releaseBody{body: resp.Body, release: release}
+			if gzipped && resp.StatusCode == http.StatusUnsupportedMediaType {
+				// the attempt does not count, the host just cannot read gzip
+				c.ui.Logf("%s %s: gzipped request refused, sending it plain\n", req.Method, req.URL.Redacted())
+				c.drainBody(resp.Body)
+				setRefusesGzip(req.URL.Host)
+				req.setBody(plain, plainLength)
+				req.Header.Del("Content-Encoding")
+				gzipped = false
+				attempts--
+				continue
+			}
+		} else {
+			metrics.HttpAttempt(req.URL.Host, time.Since(attemptStart), 0)
+			release()
+		}
 		if err != nil {
-			c.ui.Errorf("%s %s request failed: %v\n", req.Method, req.URL, err)
+			// no retries once the caller gave up
+			if ctxErr := req.Context().Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			c.ui.Errorf("%s %s request failed: %v\n", req.Method, req.URL.Redacted(), err)
 		}
 		var code int // HTTP response code
 		if resp != nil {
 			code = resp.StatusCode
+			// everyone waits when the endpoint asks to back off
+			if retryAfter, ok := RetryAfter(resp, c.Clock); ok && (code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable) {
+				limiter.Pause(retryAfter)
+			}
 		}
 
 		// Check if we should continue with retries
-		checkOk, checkErr := c.RetryCheck(req, resp, err)
+		retryCheck := c.RetryCheck
+		if req.RetryCheck != nil {
+			retryCheck = req.RetryCheck
+		}
+		checkOk, checkErr := retryCheck(req, resp, err)
 		if !checkOk {
 			if checkErr != nil {
 				err = checkErr
 			}
 			return resp, err
 		}
-		waitDelay := c.RetryWaitDelay(i, resp)
+		waitDelay := c.RetryWaitDelay(attempts, resp)
 
 		// consume any response to reuse the connection
 		if err == nil && resp != nil {
-			c.drainBody(resp.Body)
+			lastBody = c.drainBody(resp.Body)
 		}
 
 		// Check if any retries left
-		remain := c.RetryMax - i
-		if remain == 0 {
+		remain := c.RetryMax - attempts
+		if remain <= 0 {
+			break
+		}
+		if !c.RetryBudget.Take() {
+			c.ui.Logf("%s %s: retry budget used up\n", req.Method, req.URL.Redacted())
 			break
 		}
 
 		// Wait specified delay
-		desc := fmt.Sprintf("%s %s", req.Method, req.URL)
+		desc := fmt.Sprintf("%s %s", req.Method, req.URL.Redacted())
 		if code > 0 {
 			desc = fmt.Sprintf("%s (status: %d)", desc, code)
 		}
 		c.ui.Logf("%s: retrying in %s (%d left)\n", desc, waitDelay, remain)
-		time.Sleep(waitDelay)
+		metrics.HttpRetry(req.URL.Host)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-c.Clock.After(waitDelay):
+		}
 	}
 
 	if c.ErrorHandler != nil {
-		return c.ErrorHandler(resp, err, c.RetryMax)
+		return c.ErrorHandler(resp, err, attempts)
 	}
 
 	// By default, when max retries done, we close the response body and return an error without
 	// returning the response
 	if resp != nil {
 		resp.Body.Close()
+		return nil, &StatusError{
+			Method:     req.Method,
+			Url:        req.URL.Redacted(),
+			StatusCode: resp.StatusCode,
+			Attempts:   attempts,
+			Body:       lastBody,
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s giving up after %d attempts: %w", req.Method, req.URL.Redacted(), attempts, err)
 	}
-	return nil, fmt.Errorf("%s %s giving up after %d attempts", req.Method, req.URL, c.RetryMax)
+	return nil, fmt.Errorf("%s %s giving up after %d attempts", req.Method, req.URL.Redacted(), attempts)
+}
+
+// StatusError is returned when the last attempt got a response with a status that is
+// retried. Body holds the start of that response.
+type StatusError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Attempts   int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s giving up after %d attempts (status: %d)", e.Method, e.Url, e.Attempts, e.StatusCode)
 }
 
 // We need to consume response bodies to maintain http connections, but
 // limit the size we consume to respReadLimit.
 var respReadLimit = int64(4096)
 
-func (c *httpClient) drainBody(body io.ReadCloser) {
+// drainBody returns what was consumed, so it can be reported when retries run out
+func (c *httpClient) drainBody(body io.ReadCloser) []byte {
 	defer body.Close()
-	_, err := io.Copy(ioutil.Discard, io.LimitReader(body, respReadLimit))
+	consumed, err := ioutil.ReadAll(io.LimitReader(body, respReadLimit))
 	if err != nil {
 		c.ui.Errorf("error draining response body: %v\n", err)
 	}
+	return consumed
+}
+
+func (c *httpClient) Policy() RetryPolicy {
+	return RetryPolicy{
+		RetryMax:       c.RetryMax,
+		RetryWaitDelay: c.RetryWaitDelay,
+		RetryBudget:    c.RetryBudget,
+		Clock:          c.Clock,
+	}
 }
 
 func (c *httpClient) Post(url, contentType string, body io.ReadSeeker) (resp *http.Response, err error) {
-	req, err := NewRequest("POST", url, body)
+	return c.PostContext(context.Background(), url, contentType, body)
+}
+
+func (c *httpClient) PostContext(ctx context.Context, url, contentType string, body io.ReadSeeker) (resp *http.Response, err error) {
+	req, err := NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +364,11 @@ func (c *httpClient) Post(url, contentType string, body io.ReadSeeker) (resp *ht
 }
 
 func (c *httpClient) Get(url string) (resp *http.Response, err error) {
-	req, err := NewRequest("GET", url, nil)
+	return c.GetContext(context.Background(), url)
+}
+
+func (c *httpClient) GetContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,149 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// acceptEncoding is sent with every request that does not set its own
+const acceptEncoding = "gzip, deflate"
+
+// gzipRefused holds the hosts that answered a gzipped request with 415, they get plain
+// requests from then on
+var gzipRefused = struct {
+	sync.Mutex
+	hosts map[string]bool
+}{hosts: map[string]bool{}}
+
+func refusesGzip(host string) bool {
+	gzipRefused.Lock()
+	defer gzipRefused.Unlock()
+	return gzipRefused.hosts[strings.ToLower(host)]
+}
+
+func setRefusesGzip(host string) {
+	gzipRefused.Lock()
+	defer gzipRefused.Unlock()
+	gzipRefused.hosts[strings.ToLower(host)] = true
+}
+
+// gzipBody compresses the request body and returns it with the plain size, it returns nil
+// when it is not worth it
+func gzipBody(body io.ReadSeeker, minSize int) ([]byte, int, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	plain, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(plain) < minSize {
+		return nil, len(plain), nil
+	}
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(plain); err != nil {
+		return nil, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+	if b.Len() >= len(plain) {
+		return nil, len(plain), nil
+	}
+	return b.Bytes(), len(plain), nil
+}
+
+// decodeBody replaces a gzip or deflate encoded response body with one that decodes it
+// while it is read. done is called with the size received and the decoded size once the
+// body is read to the end or closed.
+func decodeBody(resp *http.Response, done func(encoding string, received, decoded int64)) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "deflate" {
+		return
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	received := &countingReader{r: resp.Body}
+	resp.Body = &decodedBody{
+		encoding: encoding,
+		body:     resp.Body,
+		received: received,
+		done:     done,
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type decodedBody struct {
+	encoding string
+	body     io.ReadCloser
+	received *countingReader
+	decoder  io.Reader
+	decoded  int64
+	done     func(encoding string, received, decoded int64)
+	once     sync.Once
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.decoder == nil {
+		decoder, err := newDecoder(b.encoding, b.received)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode %s response: %w", b.encoding, err)
+		}
+		b.decoder = decoder
+	}
+	n, err := b.decoder.Read(p)
+	b.decoded += int64(n)
+	if err == io.EOF {
+		b.report()
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	b.report()
+	return b.body.Close()
+}
+
+func (b *decodedBody) report() {
+	b.once.Do(func() {
+		if b.done != nil {
+			b.done(b.encoding, b.received.n, b.decoded)
+		}
+	})
+}
+
+// newDecoder reads the gzip header right away. Deflate is meant to be zlib wrapped, but
+// some servers send raw deflate data.
+func newDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	if encoding == "gzip" {
+		return gzip.NewReader(r)
+	}
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
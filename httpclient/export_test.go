@@ -0,0 +1,8 @@
+package httpclient
+
+// LimiterFor gives the tests the limiter of an endpoint
+var LimiterFor = limiterFor
+
+func (l *Limiter) Rate() float64 {
+	return l.rate
+}
@@ -0,0 +1,186 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that spaces out requests to an endpoint, with an optional cap
+// on requests in flight. A Retry-After response pauses it for everyone.
+type Limiter struct {
+	rate  float64
+	burst float64
+	sem   chan struct{}
+	clock Clock
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// NewLimiter allows perSecond requests with bursts of up to burst requests and at most
+// maxConcurrent requests in flight. Zero values mean no limit.
+func NewLimiter(perSecond float64, burst int, maxConcurrent int) *Limiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(perSecond)))
+	}
+	l := &Limiter{rate: perSecond, burst: float64(burst), tokens: float64(burst), clock: DefaultClock}
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// Wait blocks until a request of cost tokens may be sent, a batch costs one token per call.
+// The returned release has to be called when the request is done.
+func (l *Limiter) Wait(ctx context.Context, cost int) (release func(), err error) {
+	release = func() {}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() {
+			once.Do(func() { <-l.sem })
+		}
+	}
+	for {
+		delay := l.reserve(cost)
+		if delay <= 0 {
+			return release, nil
+		}
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-l.clock.After(delay):
+		}
+	}
+}
+
+// reserve takes cost tokens, or returns how long to wait before trying again
+func (l *Limiter) reserve(cost int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if cost < 1 {
+		cost = 1
+	}
+	// a batch larger than the bucket waits for a full bucket and leaves it in debt
+	need := math.Min(float64(cost), l.burst)
+	if l.tokens >= need {
+		l.tokens -= float64(cost)
+		return 0
+	}
+	return time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Pause holds back all requests for d, as asked by a Retry-After header
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := l.clock.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+var limiters = struct {
+	sync.Mutex
+	byUrl map[string]*Limiter
+}{byUrl: map[string]*Limiter{}}
+
+// SetRateLimit limits requests to the endpoint at rawurl, see NewLimiter. All clients share
+// it. Endpoints on the same host have limits of their own, e.g. two api keys of a provider.
+func SetRateLimit(rawurl string, perSecond float64, burst int, maxConcurrent int) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	limiters.Lock()
+	defer limiters.Unlock()
+	limiters.byUrl[limiterKey(u)] = NewLimiter(perSecond, burst, maxConcurrent)
+	return nil
+}
+
+// limiterFor returns the limiter of the endpoint, endpoints without a configured limit get
+// an unlimited one that is only paused by Retry-After
+func limiterFor(u *url.URL) *Limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+	key := limiterKey(u)
+	l, ok := limiters.byUrl[key]
+	if !ok {
+		l = NewLimiter(0, 0, 0)
+		limiters.byUrl[key] = l
+	}
+	return l
+}
+
+// limiterKey is the url without its fragment, with the case insensitive parts lower cased
+func limiterKey(u *url.URL) string {
+	key := *u
+	key.Scheme = strings.ToLower(key.Scheme)
+	key.Host = strings.ToLower(key.Host)
+	key.Fragment = ""
+	key.RawFragment = ""
+	return key.String()
+}
+
+// RetryAfter returns the delay asked for by a Retry-After header in seconds or as a date,
+// a date is counted from the time of clock
+func RetryAfter(resp *http.Response, clock Clock) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		d := date.Sub(clock.Now())
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// releaseBody gives back the concurrency slot when the caller is done with the response
+type releaseBody struct {
+	body    io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	return b.body.Read(p)
+}
+
+func (b *releaseBody) Close() error {
+	defer b.release()
+	return b.body.Close()
+}
@@ -0,0 +1,186 @@
+package httpclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+func TestLimitersAreKeyedByEndpoint(t *testing.T) {
+	parse := func(rawurl string) *url.URL {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	if err := httpclient.SetRateLimit("https://rpc.example.com/v3/key1", 5, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	limited := httpclient.LimiterFor(parse("https://RPC.example.com/v3/key1"))
+	if limited.Rate() != 5 {
+		t.Errorf("endpoint got rate %v, want 5", limited.Rate())
+	}
+	if other := httpclient.LimiterFor(parse("https://rpc.example.com/v3/key2")); other == limited || other.Rate() != 0 {
+		t.Errorf("another endpoint on the same host shares the limit")
+	}
+	if other := httpclient.LimiterFor(parse("https://rpc.example.com/v3/key1?network=2")); other == limited {
+		t.Errorf("an endpoint with another query shares the limit")
+	}
+}
+
+// newLimiter returns a limiter that tells the time by clock
+func newLimiter(t *testing.T, clock *rpctest.Clock, perSecond float64, burst int, maxConcurrent int) *httpclient.Limiter {
+	withDefaults(t, clock, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget)
+	return httpclient.NewLimiter(perSecond, burst, maxConcurrent)
+}
+
+// wait runs Wait in the background while the test moves the clock
+func wait(l *httpclient.Limiter, cost int) chan error {
+	done := make(chan error, 1)
+	go func() {
+		release, err := l.Wait(context.Background(), cost)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	return done
+}
+
+// mustNotWait fails the test when Wait has to wait
+func mustNotWait(t *testing.T, clock *rpctest.Clock, l *httpclient.Limiter, cost int) {
+	t.Helper()
+	waits := len(clock.Waits())
+	release, err := l.Wait(context.Background(), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if n := len(clock.Waits()); n != waits {
+		t.Fatalf("waited %v", clock.Waits()[waits:])
+	}
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	clock := rpctest.NewClock()
+	l := newLimiter(t, clock, 2, 3, 0)
+
+	// a full bucket lets a burst through
+	for i := 0; i < 3; i++ {
+		mustNotWait(t, clock, l, 1)
+	}
+	// then a token comes every 500ms
+	for i := 0; i < 2; i++ {
+		done := wait(l, 1)
+		clock.BlockUntil(1)
+		clock.Advance(500 * time.Millisecond)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waits := clock.Waits(); len(waits) != 2 || waits[0] != 500*time.Millisecond || waits[1] != 500*time.Millisecond {
+		t.Errorf("waited %v, want [500ms 500ms]", waits)
+	}
+
+	// an idle limiter refills up to the burst only
+	clock.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		mustNotWait(t, clock, l, 1)
+	}
+	done := wait(l, 1)
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimiterBatchCostsATokenPerCall(t *testing.T) {
+	clock := rpctest.NewClock()
+	l := newLimiter(t, clock, 2, 3, 0)
+
+	// a batch larger than the bucket waits for a full bucket and leaves it in debt
+	mustNotWait(t, clock, l, 5)
+	done := wait(l, 1)
+	clock.BlockUntil(1)
+	if waits := clock.Waits(); waits[len(waits)-1] != 1500*time.Millisecond {
+		t.Errorf("waited %v after the batch, want 1.5s", waits[len(waits)-1])
+	}
+	clock.Advance(1500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimiterMaxConcurrent(t *testing.T) {
+	clock := rpctest.NewClock()
+	l := newLimiter(t, clock, 0, 0, 2)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	first, err := l.Wait(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Wait(cancelled, 1); err != context.Canceled {
+		t.Fatalf("a third request in flight got %v, want it to wait", err)
+	}
+
+	// releasing twice frees one slot
+	first()
+	first()
+	if _, err := l.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Wait(cancelled, 1); err != context.Canceled {
+		t.Fatalf("got %v, want all slots taken", err)
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	clock := rpctest.NewClock()
+	l := newLimiter(t, clock, 0, 0, 0)
+
+	l.Pause(7 * time.Second)
+	// a shorter pause does not end it early
+	l.Pause(time.Second)
+	done := wait(l, 1)
+	clock.BlockUntil(1)
+	clock.Advance(7 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if waits := clock.Waits(); len(waits) != 1 || waits[0] != 7*time.Second {
+		t.Errorf("waited %v, want [7s]", waits)
+	}
+	mustNotWait(t, clock, l, 1)
+}
+
+func TestRetryAfterPausesOtherClients(t *testing.T) {
+	clock := rpctest.NewClock()
+	withDefaults(t, clock, httpclient.Backoff{}, nil)
+	server, _ := failingServer(t, http.Header{"Retry-After": {"7"}}, http.StatusTooManyRequests)
+
+	// without retries the first client gives up on the 429
+	if resp, err := httpclient.New(rpctest.NewScreen(""), 1).Get(server.URL); err == nil {
+		resp.Body.Close()
+	}
+	done := get(httpclient.New(rpctest.NewScreen(""), 1), server.URL)
+	clock.BlockUntil(1)
+	clock.Advance(7 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if waits := clock.Waits(); len(waits) != 1 || waits[0] != 7*time.Second {
+		t.Errorf("waited %v, want [7s]", waits)
+	}
+}
@@ -0,0 +1,112 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// MaxIdleConnsPerHost is how many keep-alive connections to a host a transport keeps open
+var MaxIdleConnsPerHost = 16
+
+// DefaultDialTimeout limits connecting to a host, including the tls handshake
+var DefaultDialTimeout = 30 * time.Second
+
+// defaultTransport is shared by all clients, so calls reuse connections
+var defaultTransport, _ = NewTransport(TransportConfig{})
+
+// TransportConfig configures how the connections to an endpoint are made. The zero value
+// uses the system certificates and the proxy of the HTTP_PROXY and HTTPS_PROXY variables.
+type TransportConfig struct {
+	// keep-alive connections kept open, MaxIdleConnsPerHost when zero
+	MaxIdleConnsPerHost int
+	// a pem bundle of certificate authorities trusted besides the system ones
+	CaFile string
+	// a pem client certificate and key for mutual tls
+	CertFile string
+	KeyFile  string
+	// Insecure accepts any server certificate, only for nodes that cannot have a valid one
+	Insecure bool
+	// a http, https or socks5 proxy url
+	Proxy string
+	// DefaultDialTimeout when zero
+	DialTimeout time.Duration
+}
+
+// TLSConfig returns the tls settings of the config, nil when it has none
+func (c TransportConfig) TLSConfig() (*tls.Config, error) {
+	if c.CaFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.Insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if c.CaFile != "" {
+		pem, err := os.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CaFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewTransport returns a pooling transport that keeps idle connections to a host open and
+// uses HTTP/2 when the server supports it
+func NewTransport(config TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 0
+	t.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = 90 * time.Second
+	t.ForceAttemptHTTP2 = true
+
+	dialTimeout := config.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	t.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = dialTimeout
+
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if config.Proxy != "" {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxy.Scheme)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	return t, nil
+}
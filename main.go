@@ -1,89 +1,131 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jaanek/jeth/config"
 	"github.com/jaanek/jeth/eth"
 	"github.com/jaanek/jeth/flags"
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/metrics"
+	"github.com/jaanek/jeth/proxy"
 	"github.com/jaanek/jeth/rpc"
 	"github.com/jaanek/jeth/ui"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-type StdInput struct {
-	RpcUrl         string `json:"rpcUrl"`
-	ChainId        string `json:"chainId"`
-	RawTransaction string `json:"tx"`
-	TransactionSig string `json:"txsig"`
-}
-
 var (
 	app = NewApp("eth api command line interface")
+	// newScreen creates the screen commands write to, it can be replaced to capture output,
+	// e.g. with rpctest.Screen
+	newScreen = ui.NewTerminalWithFormat
+	// stdin is where --stdin reads the flag values from
+	stdin io.Reader = os.Stdin
+	// stdinIsTerminal tells whether stdin is a terminal, the flag values are read from a pipe
+	// or a file without --stdin
+	stdinIsTerminal = func() bool { return terminal.IsTerminal(syscall.Stdin) }
 )
 
-type Command func(term ui.Screen, ctx *cli.Context) error
-type RpcCommand func(term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error
+type Command func(c context.Context, term ui.Screen, ctx *cli.Context) error
+type RpcCommand func(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error
+
+// outputFlags are the flags of every command that reads input from stdin and writes output
+var outputFlags = []cli.Flag{
+	flags.StdIn,
+	flags.Output,
+	flags.Json,
+}
+
+// rpcFlags are the flags of every command that sends rpc calls, the endpoint, transport and
+// retry settings
+var rpcFlags = []cli.Flag{
+	flags.Verbose,
+	flags.RpcUrl,
+	flags.RpcGroup,
+	flags.Network,
+	flags.RpcHeader,
+	flags.JwtSecret,
+	flags.RpcRecord,
+	flags.RpcReplay,
+	flags.RpcCache,
+	flags.RpcRateLimit,
+	flags.RpcMaxConcurrent,
+	flags.RpcMaxIdleConns,
+	flags.RpcCaFile,
+	flags.RpcCert,
+	flags.RpcKey,
+	flags.RpcInsecure,
+	flags.RpcProxy,
+	flags.RpcDialTimeout,
+	flags.RpcTimeout,
+	flags.RpcGzipMinSize,
+	flags.RetryAttempts,
+	flags.RetryBase,
+	flags.RetryMaxDelay,
+	flags.RetryBudget,
+	flags.RpcConsensus,
+	flags.RoundRobin,
+	flags.MetricsFile,
+}
 
 func init() {
-	app.Flags = []cli.Flag{}
+	app.Flags = []cli.Flag{
+		flags.Output,
+	}
 	app.Commands = []cli.Command{
 		{
 			Name:    "chain-id",
 			Aliases: []string{"chain"},
 			Usage:   "returns the chain id of endpoint",
-			Action:  rpcCommand(eth.ChainIdCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action:  rpcCommand(eth.ChainIdCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.Gwei,
-			},
+			),
 		},
 		{
 			Name:    "block-number",
 			Aliases: []string{"bn"},
 			Usage:   "returns the number of most recent block",
-			Action:  rpcCommand(eth.BlockNumberCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
-			},
+			Action:  rpcCommand(eth.BlockNumberCommand, ui.FormatText),
+			Flags:   append(rpcFlags, outputFlags...),
 		},
 		{
 			Name:    "gas-price",
 			Aliases: []string{"gp"},
 			Usage:   "returns the current price per gas in wei",
-			Action:  rpcCommand(eth.GasPriceCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action:  rpcCommand(eth.GasPriceCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.Gwei,
-			},
+			),
 		},
 		{
 			Name:   "tip",
 			Usage:  "returns a suggestion for a gas tip cap for dynamic fee transactions",
-			Action: rpcCommand(eth.MaxPriorityFeePerGasCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action: rpcCommand(eth.MaxPriorityFeePerGasCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.Gwei,
-			},
+			),
 		},
 		{
-			Name:    "tx-params",
-			Aliases: []string{"params"},
-			Usage:   "returns transaction params, nonce, prices, gas, etc required for signing a tx",
-			Action:  rpcCommand(eth.TransactionParamsCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
+			Name:      "tx-params",
+			Aliases:   []string{"params"},
+			Usage:     "returns transaction params, nonce, prices, gas, etc required for signing a tx",
+			ArgsUsage: "[method(types...)] [arguments...]",
+			Action:    rpcCommand(eth.TransactionParamsCommand, ui.FormatJson),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.Plain,
-				flags.RpcUrl,
 				flags.FromParam,
 				flags.ToParam,
 				flags.ValueParam,
@@ -94,104 +136,75 @@ func init() {
 				flags.BinParam,
 				flags.BinFileParam,
 				flags.MethodParam,
-				flags.Param0,
-				flags.Param1,
-				flags.Param2,
-				flags.Param3,
-				flags.Param4,
-				flags.Param5,
-				flags.Param6,
-				flags.Param7,
-				flags.Param8,
-				flags.Param9,
+				flags.ArgParam,
 				flags.NoTip,
-			},
+			),
 		},
 		{
 			Name:   "balance",
 			Usage:  "get account balance",
-			Action: rpcCommand(eth.GetAccountBalanceCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action: rpcCommand(eth.GetAccountBalanceCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.HexParam,
-			},
+			),
 		},
 		{
 			Name:    "estimate-gas",
 			Aliases: []string{"estimate"},
 			Usage:   "get estimated gas used by a tx",
-			Action:  rpcCommand(eth.EstimateGasCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action:  rpcCommand(eth.EstimateGasCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.FromParam,
 				flags.ToParam,
 				flags.ValueParam,
 				flags.ValueInEthParam,
 				flags.ValueInGweiParam,
 				flags.DataParam,
-			},
+			),
 		},
 		{
 			Name:    "tx-count",
 			Aliases: []string{"count"},
 			Usage:   "get transactions count for the from address",
-			Action:  rpcCommand(eth.TransactionsCountCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action:  rpcCommand(eth.TransactionsCountCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.HexParam,
-			},
+			),
 		},
 		{
 			Name:    "tx-send",
 			Aliases: []string{"send"},
 			Usage:   "sends previously signed transaction (message call or contract creation) to endpoint. Returns tx hash",
-			Action:  rpcCommand(eth.SendTransactionCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action:  rpcCommand(eth.SendTransactionCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.TxParam,
-			},
+			),
 		},
 		{
 			Name:   "receipt",
 			Usage:  "get transaction receipt",
-			Action: rpcCommand(eth.GetTransactionReceiptCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Action: rpcCommand(eth.GetTransactionReceiptCommand, ui.FormatText),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.HexParam,
-			},
+			),
 		},
 		{
-			Name:   "pack-values",
-			Usage:  "packs method values",
-			Action: runCommand(eth.PackValuesCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
+			Name:      "pack-values",
+			Usage:     "packs method values",
+			ArgsUsage: "[method(types...)] [arguments...]",
+			Action:    runCommand(eth.PackValuesCommand, ui.FormatJson),
+			Flags: append(append([]cli.Flag{flags.Verbose}, outputFlags...),
 				flags.Plain,
 				flags.MethodParam,
-				flags.Param0,
-				flags.Param1,
-				flags.Param2,
-				flags.Param3,
-				flags.Param4,
-				flags.Param5,
-				flags.Param6,
-				flags.Param7,
-				flags.Param8,
-				flags.Param9,
-			},
+				flags.ArgParam,
+			),
 		},
 		{
-			Name:   "call",
-			Usage:  "call method",
-			Action: rpcCommand(eth.CallMethodCommand),
-			Flags: []cli.Flag{
-				flags.Verbose,
-				flags.RpcUrl,
+			Name:      "call",
+			Usage:     "call method",
+			ArgsUsage: "[method(types...)] [arguments...]",
+			Action:    rpcCommand(eth.CallMethodCommand, ui.FormatJson),
+			Flags: append(append(rpcFlags, outputFlags...),
 				flags.FromParam,
 				flags.ToParam,
 				flags.ValueParam,
@@ -200,67 +213,432 @@ func init() {
 				flags.Plain,
 				flags.MethodParam,
 				flags.OutputTypesParam,
-				flags.Param0,
-				flags.Param1,
-				flags.Param2,
-				flags.Param3,
-				flags.Param4,
-				flags.Param5,
-				flags.Param6,
-				flags.Param7,
-				flags.Param8,
-				flags.Param9,
-			},
+				flags.ArgParam,
+			),
+		},
+		{
+			Name:   "serve",
+			Usage:  "run a json-rpc proxy to endpoint with method allow and deny lists",
+			Action: rpcCommand(proxy.ServeCommand, ui.FormatText),
+			Flags: append(rpcFlags,
+				flags.ListenAddr,
+				flags.AllowMethods,
+				flags.DenyMethods,
+				flags.ReadOnly,
+				flags.LogFile,
+			),
 		},
 	}
 }
 
-func runCommand(cmd Command) func(ctx *cli.Context) error {
+// runCommand runs cmd, its output is in format unless --output says otherwise
+func runCommand(cmd Command, format ui.Format) func(ctx *cli.Context) error {
 	return func(ctx *cli.Context) error {
-		term := ui.NewTerminal(ctx.Bool(flags.Verbose.Name))
-		err := cmd(term, ctx)
+		format, err := outputFormat(ctx, format)
 		if err != nil {
-			term.Error(err)
+			return err
+		}
+		term := newScreen(ctx.Bool(flags.Verbose.Name), format)
+		if _, err := resolveFlags(term, ctx, nil); err != nil {
+			return err
+		}
+		c, stop := interruptContext()
+		defer stop()
+		err = cmd(c, term, ctx)
+		if err != nil {
+			reportError(c, term, err)
 		}
 		return nil
 	}
 }
 
-func rpcCommand(cmd RpcCommand) func(ctx *cli.Context) error {
+// rpcCommand runs cmd with the endpoint of the flags, its output is in format unless
+// --output says otherwise
+func rpcCommand(cmd RpcCommand, format ui.Format) func(ctx *cli.Context) error {
 	return func(ctx *cli.Context) error {
-		term := ui.NewTerminal(ctx.Bool(flags.Verbose.Name))
-		var rpcUrl string
-		if ctx.IsSet(flags.RpcUrl.Name) {
-			rpcUrl = ctx.String(flags.RpcUrl.Name)
-		} else if flags.FlagRpcUrl != nil && *flags.FlagRpcUrl != "" {
-			rpcUrl = *flags.FlagRpcUrl
-		} else {
-			return errors.New(fmt.Sprintf("Missing --%s", flags.RpcUrl.Name))
-		}
-		endpoint := rpc.NewEndpoint(rpcUrl)
-		err := cmd(term, ctx, endpoint)
+		format, err := outputFormat(ctx, format)
+		if err != nil {
+			return err
+		}
+		term := newScreen(ctx.Bool(flags.Verbose.Name), format)
+		configureRetries(ctx)
+		network, err := eth.NetworkFromCli(ctx)
+		if err != nil {
+			return err
+		}
+		sources, err := resolveFlags(term, ctx, network)
+		if err != nil {
+			return err
+		}
+		endpoint, err := endpointFromCli(ctx, network, sources)
 		if err != nil {
-			term.Error(err)
+			return err
 		}
+		if c, ok := endpoint.(io.Closer); ok {
+			// a recording writes its cassette on close
+			defer func() {
+				if err := c.Close(); err != nil {
+					term.Error(err)
+				}
+			}()
+		}
+		c, stop := interruptContext()
+		defer stop()
+		c = eth.WithNetwork(c, network)
+		err = cmd(c, term, ctx, endpoint)
+		if err != nil {
+			reportError(c, term, err)
+		}
+		reportMetrics(term, ctx)
 		return nil
 	}
 }
 
-func main() {
-	// try to read command params from from std input json stream
-	if isReadFromStdInArgSpecified(os.Args) {
-		stdInStr := StdInReadAll()
-		if len(stdInStr) > 0 {
-			input := StdInput{}
-			err := json.Unmarshal([]byte(stdInStr), &input)
+// flagSources tells which flags resolveFlags set and where it took them from
+type flagSources map[string]string
+
+// resolveFlags sets the flags of the command that were not given on the command line. The
+// value of a flag is taken from the command line, then from the json object on stdin, then
+// from the network in the config file and last from its JETH_* environment variable. Stdin
+// is read with --stdin or when it is not a terminal.
+func resolveFlags(term ui.Screen, ctx *cli.Context, network *config.NetworkConfig) (flagSources, error) {
+	sources := flagSources{}
+	var input map[string]interface{}
+	if ctx.Bool(flags.StdIn.Name) || !stdinIsTerminal() {
+		var err error
+		if input, err = readStdInput(stdin); err != nil {
+			return nil, fmt.Errorf("failed to parse stdin json: %w", err)
+		}
+	}
+	for _, name := range ctx.FlagNames() {
+		value, ok := input[name]
+		if !ok {
+			value, ok = input[camelCase(name)]
+		}
+		// a network or group given on the command line replaces the url of stdin
+		if ok && name == flags.RpcUrl.Name && (onCommandLine(ctx, flags.Network.Name) || onCommandLine(ctx, flags.RpcGroup.Name)) {
+			continue
+		}
+		if !ok {
+			continue
+		}
+		values, err := stdInValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("stdin json field %s: %w", name, err)
+		}
+		if err := setFlag(term, ctx, sources, name, "stdin", values...); err != nil {
+			return nil, err
+		}
+	}
+	if network != nil && network.From != "" && sources[flags.FromParam.Name] == "" {
+		if err := setFlag(term, ctx, sources, flags.FromParam.Name, "network "+network.Name, network.From); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+// setFlag sets the flag name to values unless it is given on the command line or the
+// command does not have it. The values replace those of its environment variable.
+func setFlag(term ui.Screen, ctx *cli.Context, sources flagSources, name, source string, values ...string) error {
+	if len(values) == 0 || onCommandLine(ctx, name) || !hasFlag(ctx, name) {
+		return nil
+	}
+	term.Logf("--%s from %s\n", name, source)
+	// cli appends to the values of a slice flag
+	if slice, ok := ctx.Generic(name).(*cli.StringSlice); ok {
+		*slice = nil
+	}
+	for _, value := range values {
+		if err := ctx.Set(name, value); err != nil {
+			return fmt.Errorf("invalid --%s from %s: %w", name, source, err)
+		}
+	}
+	sources[name] = source
+	return nil
+}
+
+func hasFlag(ctx *cli.Context, name string) bool {
+	for _, n := range ctx.FlagNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readStdInput decodes the first json object of r. Its fields are named like the flags,
+// e.g. "rpc.url", or in camel case like the output of the commands, e.g. "rpcUrl".
+func readStdInput(r io.Reader) (map[string]interface{}, error) {
+	input := map[string]interface{}{}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&input); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return input, nil
+}
+
+// stdInValues returns the flag values of a json field, an array sets a flag many times
+func stdInValues(field interface{}) ([]string, error) {
+	switch v := field.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if _, nested := e.([]interface{}); nested {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			value, err := stdInValues(e)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error while parsing stdin json: %s\n", err)
-			} else {
-				flags.FlagRpcUrl = &input.RpcUrl
-				flags.FlagRawTx = &input.RawTransaction
+				return nil, err
 			}
+			values = append(values, value...)
 		}
+		return values, nil
 	}
+	return nil, errors.New("expected a string, number, bool or array")
+}
+
+// camelCase turns a flag name like rpc.max-idle-conns into rpcMaxIdleConns
+func camelCase(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '-' })
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// outputFormat returns the format of --output given to the command or before it, --json
+// and --plain are short for json and text. Without them it is the default of the command.
+func outputFormat(ctx *cli.Context, defaultFormat ui.Format) (ui.Format, error) {
+	switch {
+	case ctx.IsSet(flags.Output.Name):
+		return ui.ParseFormat(ctx.String(flags.Output.Name))
+	case ctx.Bool(flags.Json.Name):
+		return ui.FormatJson, nil
+	case ctx.Bool(flags.Plain.Name):
+		return ui.FormatText, nil
+	case ctx.GlobalIsSet(flags.Output.Name):
+		return ui.ParseFormat(ctx.GlobalString(flags.Output.Name))
+	}
+	return defaultFormat, nil
+}
+
+// configureRetries sets the retry policy of the http clients of the command
+func configureRetries(ctx *cli.Context) {
+	httpclient.DefaultRetryMax = ctx.Int(flags.RetryAttempts.Name)
+	httpclient.DefaultBackoff.Base = ctx.Duration(flags.RetryBase.Name)
+	httpclient.DefaultBackoff.Max = ctx.Duration(flags.RetryMaxDelay.Name)
+	if budget := ctx.Int(flags.RetryBudget.Name); budget > 0 {
+		httpclient.DefaultRetryBudget = httpclient.NewRetryBudget(budget)
+	}
+}
+
+// reportMetrics prints a summary of the rpc calls with --verbose and writes them to
+// --metrics.file
+func reportMetrics(term ui.Screen, ctx *cli.Context) {
+	if summary := metrics.Summary(); summary != "" {
+		term.Log("rpc calls:\n" + strings.TrimSuffix(summary, "\n"))
+	}
+	if !ctx.IsSet(flags.MetricsFile.Name) {
+		return
+	}
+	f, err := os.Create(ctx.String(flags.MetricsFile.Name))
+	if err != nil {
+		term.Errorf("failed to write metrics: %v\n", err)
+		return
+	}
+	defer f.Close()
+	metrics.WritePrometheus(f)
+}
+
+// interruptContext returns a context that is canceled on Ctrl-C, so in-flight requests and
+// retry waits are aborted
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func reportError(c context.Context, term ui.Screen, err error) {
+	if errors.Is(err, context.Canceled) && c.Err() != nil {
+		term.Error("interrupted")
+		return
+	}
+	term.Error(err)
+}
+
+func endpointFromCli(ctx *cli.Context, network *config.NetworkConfig, sources flagSources) (rpc.Endpoint, error) {
+	if ctx.IsSet(flags.RpcReplay.Name) {
+		return rpc.NewReplayEndpoint(ctx.String(flags.RpcReplay.Name))
+	}
+	endpoint, err := nodeEndpointFromCli(ctx, network, sources)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.IsSet(flags.RpcCache.Name) {
+		store, err := rpc.ParseCacheStore(ctx.String(flags.RpcCache.Name))
+		if err != nil {
+			return nil, err
+		}
+		endpoint = rpc.NewCachingEndpoint(endpoint, store)
+	}
+	if ctx.IsSet(flags.RpcRecord.Name) {
+		return rpc.NewRecordingEndpoint(endpoint, ctx.String(flags.RpcRecord.Name))
+	}
+	return endpoint, nil
+}
+
+func nodeEndpointFromCli(ctx *cli.Context, network *config.NetworkConfig, sources flagSources) (rpc.Endpoint, error) {
+	var configs []config.EndpointConfig
+	// the endpoints come from the command line, then from stdin, then from the network and
+	// last from the environment
+	useRpcUrl := onCommandLine(ctx, flags.RpcUrl.Name)
+	if !useRpcUrl && !onCommandLine(ctx, flags.Network.Name) && !onCommandLine(ctx, flags.RpcGroup.Name) {
+		useRpcUrl = sources[flags.RpcUrl.Name] != "" || network == nil && ctx.IsSet(flags.RpcUrl.Name)
+	}
+	if useRpcUrl {
+		for _, url := range ctx.StringSlice(flags.RpcUrl.Name) {
+			for _, u := range strings.Split(url, ",") {
+				configs = append(configs, config.EndpointConfig{Url: u})
+			}
+		}
+	} else if network != nil {
+		configs = network.Endpoints
+	} else if ctx.IsSet(flags.RpcGroup.Name) {
+		cfg, err := config.Load(config.DefaultPath())
+		if err != nil {
+			return nil, err
+		}
+		configs, err = cfg.EndpointGroup(ctx.String(flags.RpcGroup.Name))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New(fmt.Sprintf("Missing --%s", flags.RpcUrl.Name))
+	}
+
+	// credentials from flags apply to every endpoint
+	var cliOpts []rpc.EndpointOption
+	for _, header := range ctx.StringSlice(flags.RpcHeader.Name) {
+		split := strings.SplitN(header, ":", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid --%s %q, expected \"Name: value\"", flags.RpcHeader.Name, header)
+		}
+		cliOpts = append(cliOpts, rpc.WithHeader(strings.TrimSpace(split[0]), strings.TrimSpace(split[1])))
+	}
+	if ctx.IsSet(flags.JwtSecret.Name) {
+		secret, err := rpc.ReadJwtSecret(ctx.String(flags.JwtSecret.Name))
+		if err != nil {
+			return nil, err
+		}
+		cliOpts = append(cliOpts, rpc.WithJwtSecret(secret))
+	}
+
+	var endpoints []rpc.Endpoint
+	for _, c := range configs {
+		opts := append([]rpc.EndpointOption{}, cliOpts...)
+		for name, value := range c.Headers {
+			opts = append(opts, rpc.WithHeader(name, value))
+		}
+		if c.JwtSecretFile != "" {
+			secret, err := rpc.ReadJwtSecret(c.JwtSecretFile)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, rpc.WithJwtSecret(secret))
+		}
+		url := strings.TrimSpace(c.Url)
+		if ctx.IsSet(flags.RpcRateLimit.Name) {
+			c.RateLimit = ctx.Float64(flags.RpcRateLimit.Name)
+		}
+		if ctx.IsSet(flags.RpcMaxConcurrent.Name) {
+			c.MaxConcurrent = ctx.Int(flags.RpcMaxConcurrent.Name)
+		}
+		transportOpts, err := transportFromCli(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", rpc.RedactUrl(url), err)
+		}
+		opts = append(opts, transportOpts...)
+		if c.RateLimit > 0 || c.MaxConcurrent > 0 {
+			if err := httpclient.SetRateLimit(url, c.RateLimit, c.RateBurst, c.MaxConcurrent); err != nil {
+				return nil, err
+			}
+		}
+		endpoint := rpc.NewEndpoint(url, opts...)
+		if network != nil && network.ChainId != 0 {
+			endpoint = rpc.NewChainCheckEndpoint(endpoint, network.ChainId)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if ctx.IsSet(flags.RpcConsensus.Name) {
+		return rpc.NewConsensusEndpoint(endpoints, ctx.Int(flags.RpcConsensus.Name)), nil
+	}
+	if len(endpoints) > 1 || ctx.Bool(flags.RoundRobin.Name) {
+		return rpc.NewFailover(endpoints, ctx.Bool(flags.RoundRobin.Name)), nil
+	}
+	return endpoints[0], nil
+}
+
+// transportFromCli returns the connection settings of an endpoint, flags take precedence
+// over the config file
+func transportFromCli(ctx *cli.Context, c config.EndpointConfig) ([]rpc.EndpointOption, error) {
+	if ctx.IsSet(flags.RpcMaxIdleConns.Name) {
+		c.MaxIdleConns = ctx.Int(flags.RpcMaxIdleConns.Name)
+	}
+	if ctx.IsSet(flags.RpcCaFile.Name) {
+		c.CaFile = ctx.String(flags.RpcCaFile.Name)
+	}
+	if ctx.IsSet(flags.RpcCert.Name) {
+		c.CertFile = ctx.String(flags.RpcCert.Name)
+	}
+	if ctx.IsSet(flags.RpcKey.Name) {
+		c.KeyFile = ctx.String(flags.RpcKey.Name)
+	}
+	if ctx.IsSet(flags.RpcInsecure.Name) {
+		c.Insecure = ctx.Bool(flags.RpcInsecure.Name)
+	}
+	if ctx.IsSet(flags.RpcProxy.Name) {
+		c.Proxy = ctx.String(flags.RpcProxy.Name)
+	}
+	if url := strings.TrimSpace(c.Url); c.Proxy != "" && (rpc.IsWebsocketUrl(url) || rpc.IsIpcPath(url)) {
+		return nil, fmt.Errorf("a proxy only applies to http endpoints, see --%s", flags.RpcProxy.Name)
+	}
+	if ctx.IsSet(flags.RpcDialTimeout.Name) || c.DialTimeout == 0 {
+		c.DialTimeout = config.Duration(ctx.Duration(flags.RpcDialTimeout.Name))
+	}
+	if ctx.IsSet(flags.RpcTimeout.Name) || c.Timeout == 0 {
+		c.Timeout = config.Duration(ctx.Duration(flags.RpcTimeout.Name))
+	}
+	if ctx.IsSet(flags.RpcGzipMinSize.Name) {
+		c.GzipMinSize = ctx.Int(flags.RpcGzipMinSize.Name)
+	}
+	transport, err := httpclient.NewTransport(httpclient.TransportConfig{
+		MaxIdleConnsPerHost: c.MaxIdleConns,
+		CaFile:              c.CaFile,
+		CertFile:            c.CertFile,
+		KeyFile:             c.KeyFile,
+		Insecure:            c.Insecure,
+		Proxy:               c.Proxy,
+		DialTimeout:         time.Duration(c.DialTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []rpc.EndpointOption{
+		rpc.WithTransport(transport),
+		rpc.WithTimeout(time.Duration(c.Timeout)),
+		rpc.WithGzipRequests(c.GzipMinSize),
+	}, nil
+}
+
+func main() {
 	if err := app.Run(os.Args); err != nil {
 		code := 1
 		fmt.Fprintln(os.Stderr, err)
@@ -278,26 +656,29 @@ func NewApp(usage string) *cli.App {
 	return app
 }
 
-func isReadFromStdInArgSpecified(args []string) bool {
+// onCommandLine tells whether the flag name is given on the command line of the command
+// of ctx. cli treats a flag given by its environment variable as set too.
+func onCommandLine(ctx *cli.Context, name string) bool {
+	if !ctx.IsSet(name) {
+		return false
+	}
+	// the context of the app keeps the command line from the command name on, the context
+	// of the command only the arguments after its flags
+	args := ctx.Args()
+	if parent := ctx.Parent(); parent != nil {
+		args = parent.Args()
+	}
 	for _, arg := range args {
 		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
 			return true
 		}
 	}
 	return false
 }
-
-func StdInReadAll() string {
-	arr := make([]string, 0)
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		scanner.Scan()
-		text := scanner.Text()
-		if len(text) > 0 {
-			arr = append(arr, text)
-		} else {
-			break
-		}
-	}
-	return strings.Join(arr, "")
-}
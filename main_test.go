@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/crypto"
+)
+
+var (
+	alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	token = common.HexToAddress("0x0000000000000000000000000000000000070c3e")
+	// balanceOf(address) of alice
+	balanceOfAlice = hexutil.MustDecode("0x70a0823100000000000000000000000000000000000000000000000000000000000a11ce")
+	txHash         = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
+)
+
+// runApp runs the command line args and returns the screen the command wrote to
+func runApp(t *testing.T, args ...string) *rpctest.Screen {
+	var term *rpctest.Screen
+	newScreen = func(verbose bool, format ui.Format) ui.Screen {
+		term = rpctest.NewScreen("")
+		term.Format = format
+		return term
+	}
+	// commands set the retry policy of the http clients from their flags
+	retryMax, backoff, budget := httpclient.DefaultRetryMax, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget
+	// stdin of the test is not a terminal, commands would read it
+	isTerminal := stdinIsTerminal
+	if stdin == os.Stdin {
+		stdinIsTerminal = func() bool { return true }
+	}
+	t.Cleanup(func() {
+		newScreen = ui.NewTerminalWithFormat
+		httpclient.DefaultRetryMax, httpclient.DefaultBackoff, httpclient.DefaultRetryBudget = retryMax, backoff, budget
+		stdinIsTerminal = isTerminal
+	})
+	if err := app.Run(append([]string{"jeth"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if term == nil {
+		t.Fatal("the command did not create a screen")
+	}
+	return term
+}
+
+// signedTransfer returns a raw transaction sending 1 wei to alice on chain 1337
+func signedTransfer(t *testing.T) []byte {
+	key, err := crypto.HexToECDSA("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(0, alice, uint256.NewInt(1), 21000, uint256.NewInt(1000000000), nil)
+	signed, err := types.SignTx(tx, *types.LatestSignerForChainID(uint256.NewInt(1337).ToBig()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	if err := signed.MarshalBinary(&raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw.Bytes()
+}
+
+func TestCommands(t *testing.T) {
+	transientError := &rpc.RpcError{Code: -32000, Message: "header not found"}
+	tests := []struct {
+		name  string
+		setup func(s *rpctest.Server)
+		args  []string
+		want  string
+		// err is part of the error the command reports, empty when it succeeds
+		err      string
+		requests []string
+	}{
+		{
+			name:     "chain-id",
+			args:     []string{"chain-id"},
+			want:     "1337\n",
+			requests: []string{"eth_chainId"},
+		},
+		{
+			name: "chain-id as json",
+			args: []string{"chain-id", "--json"},
+			want: `{"chainId":"1337"}` + "\n",
+		},
+		{
+			name:     "block-number",
+			setup:    func(s *rpctest.Server) { s.SetBlockNumber(12345) },
+			args:     []string{"bn"},
+			want:     "12345\n",
+			requests: []string{"eth_blockNumber"},
+		},
+		{
+			name:  "gas-price in gwei",
+			setup: func(s *rpctest.Server) { s.SetGasPrice(uint256.NewInt(25000000000)) },
+			args:  []string{"gas-price", "--gwei"},
+			want:  "25\n",
+		},
+		{
+			name: "tip",
+			args: []string{"tip"},
+			want: "1000000000\n",
+		},
+		{
+			name:  "tip on a node without eth_maxPriorityFeePerGas",
+			setup: func(s *rpctest.Server) { s.SetMaxPriorityFee(nil) },
+			args:  []string{"tip"},
+			err:   "the method eth_maxPriorityFeePerGas does not exist",
+		},
+		{
+			name:     "balance",
+			setup:    func(s *rpctest.Server) { s.SetBalance(alice, uint256.NewInt(5)) },
+			args:     []string{"balance", "--param", alice.Hex()},
+			want:     "5\n",
+			requests: []string{"eth_getBalance"},
+		},
+		{
+			name: "balance needs an address",
+			args: []string{"balance"},
+			err:  "Missing address --param",
+		},
+		{
+			name:  "tx-count",
+			setup: func(s *rpctest.Server) { s.SetNonce(alice, 7, 9) },
+			args:  []string{"count", "--param", alice.Hex()},
+			want:  "7\n",
+		},
+		{
+			name:  "estimate-gas",
+			setup: func(s *rpctest.Server) { s.SetGasEstimate(50000) },
+			args:  []string{"estimate-gas", "--from", alice.Hex(), "--to", token.Hex(), "--data", "0x01"},
+			want:  "50000\n",
+		},
+		{
+			name: "tx-params",
+			setup: func(s *rpctest.Server) {
+				s.SetBalance(alice, uint256.NewInt(2000000000000000000))
+				s.SetNonce(alice, 3, 4)
+			},
+			args:     []string{"tx-params", "--from", alice.Hex(), "--to", token.Hex(), "--value", "1", "--value-eth"},
+			want:     `{"rpcUrl":"$url","chainId":"0x539","from":"0x00000000000000000000000000000000000A11cE","to":"0x0000000000000000000000000000000000070C3e","value":"0xde0b6b3a7640000","data":"0x","method":"","gasTip":"0x3b9aca00","gasPrice":"0x3b9aca00","gas":"21000","txCount":"3","txCountPending":"4","balance":"0x1bc16d674ec80000"}` + "\n",
+			requests: []string{"eth_maxPriorityFeePerGas", "eth_gasPrice", "eth_chainId", "eth_getTransactionCount", "eth_getTransactionCount", "eth_getBalance", "eth_estimateGas"},
+		},
+		{
+			name:  "tx-params without eth_maxPriorityFeePerGas",
+			setup: func(s *rpctest.Server) { s.SetMaxPriorityFee(nil) },
+			args:  []string{"tx-params", "--from", alice.Hex(), "--to", token.Hex(), "transfer(address,uint256)", alice.Hex(), "5"},
+			want:  `{"rpcUrl":"$url","chainId":"0x539","from":"0x00000000000000000000000000000000000A11cE","to":"0x0000000000000000000000000000000000070C3e","value":"0x0","data":"0xa9059cbb00000000000000000000000000000000000000000000000000000000000a11ce0000000000000000000000000000000000000000000000000000000000000005","method":"transfer(address,uint256)","gasPrice":"0x3b9aca00","gas":"21000","txCount":"0","txCountPending":"0","balance":"0x0"}` + "\n",
+		},
+		{
+			name:     "tx-params retries a transient error",
+			setup:    func(s *rpctest.Server) { s.FailNext("eth_gasPrice", transientError) },
+			args:     []string{"tx-params", "--retry.base", "1ms", "--from", alice.Hex(), "--to", token.Hex(), "--value", "1"},
+			want:     `{"rpcUrl":"$url","chainId":"0x539","from":"0x00000000000000000000000000000000000A11cE","to":"0x0000000000000000000000000000000000070C3e","value":"0x1","data":"0x","method":"","gasTip":"0x3b9aca00","gasPrice":"0x3b9aca00","gas":"21000","txCount":"0","txCountPending":"0","balance":"0x0"}` + "\n",
+			requests: []string{"eth_maxPriorityFeePerGas", "eth_gasPrice", "eth_chainId", "eth_getTransactionCount", "eth_getTransactionCount", "eth_getBalance", "eth_estimateGas", "eth_gasPrice"},
+		},
+		{
+			name: "tx-params gives up after the retry attempts",
+			setup: func(s *rpctest.Server) {
+				s.FailNext("eth_gasPrice", transientError)
+				s.FailNext("eth_gasPrice", transientError)
+			},
+			args: []string{"tx-params", "--retry.attempts", "2", "--retry.base", "1ms", "--from", alice.Hex(), "--to", token.Hex(), "--value", "1"},
+			err:  "failed to retrieve gasPrice: eth_gasPrice: code: -32000, message: header not found",
+		},
+		{
+			name:     "retries a failed http request",
+			setup:    func(s *rpctest.Server) { s.FailNextHttp(http.StatusBadGateway) },
+			args:     []string{"chain-id", "--retry.base", "1ms"},
+			want:     "1337\n",
+			requests: []string{"eth_chainId"},
+		},
+		{
+			name:  "call",
+			setup: func(s *rpctest.Server) { s.SetCallResult(token, balanceOfAlice, common.LeftPadBytes([]byte{42}, 32)) },
+			args:  []string{"call", "--to", token.Hex(), "--out", "uint256", "balanceOf(address)", alice.Hex()},
+			want:  `{"result":"0x000000000000000000000000000000000000000000000000000000000000002a","unpacked":[{"type":"uint256","value":42}]}` + "\n",
+		},
+		{
+			name:  "call reverts",
+			setup: func(s *rpctest.Server) { s.SetCallRevert(token, nil, "not allowed") },
+			args:  []string{"call", "--to", token.Hex(), "balanceOf(address)", alice.Hex()},
+			err:   "(reason: not allowed)",
+		},
+		{
+			name: "pack-values",
+			args: []string{"pack-values", "transfer(address,uint256)", alice.Hex(), "5"},
+			want: `{"methodSig":"transfer(address,uint256)","methodHashed":"a9059cbb","packedValues":"00000000000000000000000000000000000000000000000000000000000a11ce0000000000000000000000000000000000000000000000000000000000000005"}` + "\n",
+		},
+		{
+			name: "pack-values of a method without arguments",
+			args: []string{"pack-values", "f()"},
+			want: `{"methodSig":"f()","methodHashed":"26121ff0","packedValues":""}` + "\n",
+		},
+		{
+			name: "receipt",
+			setup: func(s *rpctest.Server) {
+				s.AddReceipt(txHash, map[string]string{"transactionHash": txHash.Hex(), "status": "0x1"}, 0)
+			},
+			args: []string{"receipt", "--param", txHash.Hex(), "--output", "yaml"},
+			want: "blockHash: \"\"\nblockNumber: \"\"\ncontractAddress: \"\"\ncumulativeGasUsed: \"\"\neffectiveGasPrice: \"\"\nfrom: \"\"\ngasUsed: \"\"\nlogs: null\nlogsBloom: \"\"\nstatus: \"0x1\"\nto: \"\"\ntransactionHash: \"" + txHash.Hex() + "\"\ntransactionIndex: \"\"\ntype: \"\"\n",
+		},
+		{
+			name: "receipt of a transaction that is not mined",
+			setup: func(s *rpctest.Server) {
+				s.AddReceipt(txHash, map[string]string{"transactionHash": txHash.Hex()}, 1)
+			},
+			args: []string{"receipt", "--param", txHash.Hex(), "--json"},
+			want: "null\n",
+		},
+		{
+			name:     "tx-send",
+			setup:    func(s *rpctest.Server) { s.AutoMine(0) },
+			args:     []string{"send", "--tx", hexutil.Encode(signedTransfer(t))},
+			want:     crypto.Keccak256Hash(signedTransfer(t)).Hex() + "\n",
+			requests: []string{"eth_chainId", "eth_sendRawTransaction", "eth_getTransactionReceipt", "eth_blockNumber"},
+		},
+		{
+			name:  "tx-send to another chain",
+			setup: func(s *rpctest.Server) { s.SetChainId(1) },
+			args:  []string{"send", "--tx", hexutil.Encode(signedTransfer(t))},
+			err:   "endpoint chain-id: 1 not same as tx chain-id: 1337",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := rpctest.NewServer()
+			defer server.Close()
+			if test.setup != nil {
+				test.setup(server)
+			}
+			t.Setenv("JETH_RPC_URL", server.URL)
+			term := runApp(t, test.args...)
+
+			errs := term.Errors()
+			if test.err != "" {
+				if len(errs) != 1 || !strings.Contains(errs[0], test.err) {
+					t.Fatalf("got errors %q, want one with %q", errs, test.err)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors %q", errs)
+			}
+			if want := strings.ReplaceAll(test.want, "$url", server.URL); term.Stdout() != want {
+				t.Errorf("got  %s\nwant %s", term.Stdout(), want)
+			}
+			if got := server.Requests(); test.requests != nil && !reflect.DeepEqual(got, test.requests) {
+				t.Errorf("requests %v, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestTxParamsCredentials(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	url := strings.Replace(server.URL, "http://", "http://user:secret@", 1)
+	args := []string{"tx-params", "--rpc.url", url, "--from", alice.Hex(), "--to", token.Hex(), "--value", "1"}
+
+	// tx-send reads the url from the json on stdin, it needs the password
+	term := runApp(t, args...)
+	if !strings.Contains(term.Stdout(), `"rpcUrl":"`+url+`"`) {
+		t.Errorf("json output without the url of the node: %s", term.Stdout())
+	}
+	term = runApp(t, append(args, "--plain")...)
+	if out := term.Stdout(); strings.Contains(out, "secret") || !strings.Contains(out, "rpcUrl: http://user:xxxxx@") {
+		t.Errorf("text output does not hide the password: %s", out)
+	}
+}
+
+// writeConfig writes a config file with the network dev on server for the commands of a test
+func writeConfig(t *testing.T, server *rpctest.Server, fees string) {
+	writeNetwork(t, `{"endpoints":["`+server.URL+`"],"chainId":1337,"fees":`+fees+`}`)
+}
+
+// writeNetwork writes a config file with the json object dev as the network dev
+func writeNetwork(t *testing.T, dev string) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "jeth"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `{"networks":{"dev":` + dev + `}}`
+	if err := os.WriteFile(filepath.Join(dir, "jeth", "config.json"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkFeePolicy(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+
+	writeConfig(t, server, `{"gasPriceMultiplier":1.5,"maxGasPriceGwei":2}`)
+	term := runApp(t, "tx-params", "--network", "dev", "--from", alice.Hex(), "--to", token.Hex(), "--value", "1")
+	if errs := term.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %q", errs)
+	}
+	if !strings.Contains(term.Stdout(), `"gasPrice":"0x59682f00"`) {
+		t.Errorf("gas price is not scaled by 1.5: %s", term.Stdout())
+	}
+
+	// the signed transfer pays 1 gwei per gas
+	writeConfig(t, server, `{"maxGasPriceGwei":0.5}`)
+	for _, args := range [][]string{
+		{"tx-params", "--network", "dev", "--from", alice.Hex(), "--to", token.Hex(), "--value", "1"},
+		{"send", "--network", "dev", "--tx", hexutil.Encode(signedTransfer(t))},
+	} {
+		term := runApp(t, args...)
+		if errs := term.Errors(); len(errs) != 1 || !strings.Contains(errs[0], "gas price of 1 gwei is above the max of 0.5 gwei") {
+			t.Errorf("%s: got errors %q, want the max gas price", args[0], errs)
+		}
+	}
+	if n := len(server.SentTransactions()); n != 0 {
+		t.Errorf("sent %d transactions above the max gas price", n)
+	}
+}
+
+// unreadStdin fails the test when a command reads it
+type unreadStdin struct{ t *testing.T }
+
+func (r unreadStdin) Read(p []byte) (int, error) {
+	r.t.Error("the command read stdin")
+	return 0, io.EOF
+}
+
+func TestStdin(t *testing.T) {
+	minusFive := "fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffb"
+	tests := []struct {
+		name     string
+		terminal bool
+		stdin    func(t *testing.T) io.Reader
+		args     []string
+	}{
+		{
+			name:     "-- ends the flags on a terminal",
+			terminal: true,
+			stdin:    func(t *testing.T) io.Reader { return unreadStdin{t} },
+			args:     []string{"pack-values", "f(int256)", "--", "-5"},
+		},
+		{
+			name:  "-- ends the flags of a pipe",
+			stdin: func(t *testing.T) io.Reader { return strings.NewReader("") },
+			args:  []string{"pack-values", "f(int256)", "--", "-5"},
+		},
+		{
+			name:  "flags from a pipe",
+			stdin: func(t *testing.T) io.Reader { return strings.NewReader(`{"arg":["-5"]}`) },
+			args:  []string{"pack-values", "f(int256)"},
+		},
+		{
+			name:     "flags from a terminal with --stdin",
+			terminal: true,
+			stdin:    func(t *testing.T) io.Reader { return strings.NewReader(`{"method":"f(int256)","arg":["-5"]}`) },
+			args:     []string{"pack-values", "--stdin"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(r io.Reader, isTerminal func() bool) { stdin, stdinIsTerminal = r, isTerminal }(stdin, stdinIsTerminal)
+			stdin = test.stdin(t)
+			stdinIsTerminal = func() bool { return test.terminal }
+
+			term := runApp(t, test.args...)
+			if errs := term.Errors(); len(errs) > 0 {
+				t.Fatalf("unexpected errors %q", errs)
+			}
+			if out := term.Stdout(); !strings.Contains(out, `"packedValues":"`+minusFive+`"`) {
+				t.Errorf("got %s, want -5 packed", out)
+			}
+		})
+	}
+}
+
+func TestFlagPrecedence(t *testing.T) {
+	var (
+		bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+		carol = common.HexToAddress("0x00000000000000000000000000000000000ca201")
+		dave  = common.HexToAddress("0x0000000000000000000000000000000000000da7")
+	)
+	// a node for each source, the one that answers tells where the url came from
+	nodes := map[string]*rpctest.Server{}
+	for _, source := range []string{"flag", "stdin", "network", "env"} {
+		nodes[source] = rpctest.NewServer()
+		defer nodes[source].Close()
+	}
+	stdinJson := `{"from":"` + dave.Hex() + `","rpcUrl":"` + nodes["stdin"].URL + `"}`
+	tests := []struct {
+		name    string
+		network bool
+		stdin   string
+		args    []string
+		from    common.Address
+		node    string
+	}{
+		{name: "environment", from: bob, node: "env"},
+		{name: "network over environment", network: true, from: carol, node: "network"},
+		{name: "stdin over network", network: true, stdin: stdinJson, from: dave, node: "stdin"},
+		{name: "flags over stdin", network: true, stdin: stdinJson, args: []string{"--from", alice.Hex(), "--rpc.url", nodes["flag"].URL}, from: alice, node: "flag"},
+		{name: "network flag over stdin", stdin: stdinJson, args: []string{"--network", "dev"}, from: dave, node: "network"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeNetwork(t, `{"endpoints":["`+nodes["network"].URL+`"],"chainId":1337,"from":"`+carol.Hex()+`"}`)
+			t.Setenv("JETH_RPC_URL", nodes["env"].URL)
+			t.Setenv("JETH_FROM", bob.Hex())
+			if test.network {
+				t.Setenv("JETH_NETWORK", "dev")
+			}
+			defer func(r io.Reader, isTerminal func() bool) { stdin, stdinIsTerminal = r, isTerminal }(stdin, stdinIsTerminal)
+			stdin = strings.NewReader(test.stdin)
+			stdinIsTerminal = func() bool { return test.stdin == "" }
+			requests := map[string]int{}
+			for source, node := range nodes {
+				requests[source] = len(node.Requests())
+			}
+
+			args := append([]string{"tx-params", "--to", token.Hex(), "--value", "1"}, test.args...)
+			term := runApp(t, args...)
+			if errs := term.Errors(); len(errs) > 0 {
+				t.Fatalf("unexpected errors %q", errs)
+			}
+			if !strings.Contains(term.Stdout(), `"from":"`+test.from.Hex()+`"`) {
+				t.Errorf("want from %s: %s", test.from.Hex(), term.Stdout())
+			}
+			for source, node := range nodes {
+				if asked := len(node.Requests()) > requests[source]; asked != (source == test.node) {
+					t.Errorf("the %s node was asked: %v, want the %s node", source, asked, test.node)
+				}
+			}
+		})
+	}
+}
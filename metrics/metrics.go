@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	vm "github.com/VictoriaMetrics/metrics"
+)
+
+// set holds the prometheus metrics of rpc calls and http requests
+var set = vm.NewSet()
+
+type callStats struct {
+	calls    int
+	errors   int
+	total    time.Duration
+	max      time.Duration
+	sent     int
+	received int
+}
+
+// run aggregates the calls of this process for Summary
+var run = struct {
+	sync.Mutex
+	calls      map[string]*callStats
+	retries    int
+	rpcRetries int
+}{calls: map[string]*callStats{}}
+
+// knownMethods are the method labels kept as they are, any other method is counted as "other",
+// so clients of a proxy cannot add a series for every made up method name
+var knownMethods = struct {
+	sync.RWMutex
+	known map[string]bool
+}{known: map[string]bool{"batch": true}}
+
+// AddMethods adds names to the methods that get a label of their own
+func AddMethods(names ...string) {
+	knownMethods.Lock()
+	defer knownMethods.Unlock()
+	for _, name := range names {
+		knownMethods.known[name] = true
+	}
+}
+
+func methodLabel(method string) string {
+	knownMethods.RLock()
+	defer knownMethods.RUnlock()
+	if knownMethods.known[method] {
+		return method
+	}
+	return "other"
+}
+
+// RpcCall records a json-rpc call or batch that took d. Code is empty on success, otherwise
+// the json-rpc error code, "http_<status>" or "transport".
+func RpcCall(method string, d time.Duration, code string, sent, received int) {
+	method = methodLabel(method)
+	set.GetOrCreateHistogram(fmt.Sprintf(`jeth_rpc_call_duration_seconds{method=%q}`, method)).Update(d.Seconds())
+	set.GetOrCreateCounter(fmt.Sprintf(`jeth_rpc_calls_total{method=%q}`, method)).Inc()
+	set.GetOrCreateHistogram(fmt.Sprintf(`jeth_rpc_request_size_bytes{method=%q}`, method)).Update(float64(sent))
+	if received > 0 {
+		set.GetOrCreateHistogram(fmt.Sprintf(`jeth_rpc_response_size_bytes{method=%q}`, method)).Update(float64(received))
+	}
+	if code != "" {
+		RpcError(method, code)
+	}
+
+	run.Lock()
+	defer run.Unlock()
+	stats, ok := run.calls[method]
+	if !ok {
+		stats = &callStats{}
+		run.calls[method] = stats
+	}
+	stats.calls++
+	stats.total += d
+	if d > stats.max {
+		stats.max = d
+	}
+	stats.sent += sent
+	stats.received += received
+	if code != "" {
+		stats.errors++
+	}
+}
+
+// RpcError counts a failed call, e.g. a single call of a batch
+func RpcError(method, code string) {
+	set.GetOrCreateCounter(fmt.Sprintf(`jeth_rpc_errors_total{method=%q,code=%q}`, methodLabel(method), label(code))).Inc()
+}
+
+// HttpAttempt records a http request attempt to host, status is zero when no response came
+func HttpAttempt(host string, d time.Duration, status int) {
+	set.GetOrCreateHistogram(fmt.Sprintf(`jeth_http_request_duration_seconds{host=%q}`, label(host))).Update(d.Seconds())
+	set.GetOrCreateCounter(fmt.Sprintf(`jeth_http_requests_total{host=%q,status="%d"}`, label(host), status)).Inc()
+}
+
+// HttpRetry counts a retried http request to host
+func HttpRetry(host string) {
+	set.GetOrCreateCounter(fmt.Sprintf(`jeth_http_retries_total{host=%q}`, label(host))).Inc()
+	run.Lock()
+	run.retries++
+	run.Unlock()
+}
+
+// RpcRetry counts a call sent again after a json-rpc error or a failed write
+func RpcRetry(method string) {
+	set.GetOrCreateCounter(fmt.Sprintf(`jeth_rpc_retries_total{method=%q}`, methodLabel(method))).Inc()
+	run.Lock()
+	run.rpcRetries++
+	run.Unlock()
+}
+
+// WritePrometheus writes all metrics in the prometheus text format
+func WritePrometheus(w io.Writer) {
+	set.WritePrometheus(w)
+}
+
+// Summary returns a table of the calls made by this process, empty when there were none
+func Summary() string {
+	run.Lock()
+	defer run.Unlock()
+	if len(run.calls) == 0 {
+		return ""
+	}
+	methods := make([]string, 0, len(run.calls))
+	for method := range run.calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "method\tcalls\terrors\tavg\tmax\tsent\treceived")
+	for _, method := range methods {
+		s := run.calls[method]
+		avg := s.total / time.Duration(s.calls)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%dB\t%dB\n", method, s.calls, s.errors, avg.Round(time.Millisecond), s.max.Round(time.Millisecond), s.sent, s.received)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "http retries: %d, rpc retries: %d\n", run.retries, run.rpcRetries)
+	return b.String()
+}
+
+// label keeps label values printable
+func label(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/VictoriaMetrics/metrics"
+)
+
+// resetMetrics starts a test with no calls recorded
+func resetMetrics() {
+	set = vm.NewSet()
+	run.Lock()
+	defer run.Unlock()
+	run.calls = map[string]*callStats{}
+	run.retries, run.rpcRetries = 0, 0
+}
+
+func TestUnknownMethodsShareALabel(t *testing.T) {
+	resetMetrics()
+	AddMethods("eth_chainId")
+	RpcCall("eth_chainId", time.Millisecond, "", 10, 10)
+	RpcCall("made_up_1", time.Millisecond, "-32601", 10, 10)
+	RpcCall("made_up_2", time.Millisecond, "-32601", 10, 10)
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`jeth_rpc_calls_total{method="eth_chainId"} 1`,
+		`jeth_rpc_calls_total{method="other"} 2`,
+		`jeth_rpc_errors_total{method="other",code="-32601"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "made_up") {
+		t.Errorf("unknown method got a label of its own:\n%s", out)
+	}
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/ui"
+)
+
+// MaxRequestSize limits the body of a request to the proxy
+var MaxRequestSize = int64(5 * 1024 * 1024)
+
+// subscriptions need a connection to deliver notifications on, they are never proxied
+var unsupported = map[string]bool{
+	"eth_subscribe":   true,
+	"eth_unsubscribe": true,
+}
+
+type Config struct {
+	// methods that are served, all when empty
+	Allow []string
+	// methods that are refused, checked after Allow
+	Deny []string
+	// refuse methods that change state, e.g. eth_sendRawTransaction
+	ReadOnly bool
+	// every call is logged to Log as a json line
+	Log io.Writer
+}
+
+// Proxy is a http handler that passes json-rpc requests on to an endpoint after checking
+// them against its method lists
+type Proxy struct {
+	term     ui.Screen
+	client   httpclient.HttpClient
+	endpoint rpc.Endpoint
+	allow    map[string]bool
+	deny     map[string]bool
+	readOnly bool
+
+	logMu sync.Mutex
+	log   io.Writer
+}
+
+type request struct {
+	Id      json.RawMessage `json:"id,omitempty"`
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   *rpc.RpcError   `json:"error"`
+}
+
+type logEntry struct {
+	Time       time.Time       `json:"time"`
+	Remote     string          `json:"remote"`
+	Method     string          `json:"method"`
+	Id         json.RawMessage `json:"id,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	Denied     bool            `json:"denied,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func New(term ui.Screen, client httpclient.HttpClient, endpoint rpc.Endpoint, config Config) *Proxy {
+	p := &Proxy{
+		term:     term,
+		client:   client,
+		endpoint: endpoint,
+		deny:     map[string]bool{},
+		readOnly: config.ReadOnly,
+		log:      config.Log,
+	}
+	if len(config.Allow) > 0 {
+		p.allow = map[string]bool{}
+		for _, method := range config.Allow {
+			p.allow[method] = true
+		}
+	}
+	for _, method := range config.Deny {
+		p.deny[method] = true
+	}
+	return p
+}
+
+// Allowed reports whether the proxy passes method on
+func (p *Proxy) Allowed(method string) bool {
+	if unsupported[method] || p.deny[method] {
+		return false
+	}
+	if p.readOnly && rpc.IsWriteMethod(method) {
+		return false
+	}
+	return p.allow == nil || p.allow[method]
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	start := time.Now()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > MaxRequestSize {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	batch := len(body) > 0 && body[0] == '['
+
+	var reqs []request
+	if batch {
+		err = json.Unmarshal(body, &reqs)
+	} else {
+		var req request
+		err = json.Unmarshal(body, &req)
+		reqs = append(reqs, req)
+	}
+	if err != nil || len(reqs) == 0 {
+		writeJson(w, http.StatusOK, response{Version: "2.0", Id: json.RawMessage("null"), Error: &rpc.RpcError{Code: -32700, Message: "parse error"}})
+		return
+	}
+
+	// refused calls are answered here, the rest go upstream in one message
+	var allowed []request
+	var refused []json.RawMessage
+	for _, req := range reqs {
+		if p.Allowed(req.Method) {
+			allowed = append(allowed, req)
+			continue
+		}
+		p.logCall(r, req, start, true, "not allowed")
+		if len(req.Id) > 0 {
+			msg, _ := json.Marshal(response{Version: "2.0", Id: req.Id, Error: &rpc.RpcError{
+				Code:    rpc.CodeMethodNotFound,
+				Message: fmt.Sprintf("the method %s is not allowed by this proxy", req.Method),
+			}})
+			refused = append(refused, msg)
+		}
+	}
+
+	var answers []json.RawMessage
+	if len(allowed) > 0 {
+		payload := body
+		if len(allowed) != len(reqs) {
+			payload, _ = json.Marshal(allowed)
+		}
+		resp, err := rpc.Forward(r.Context(), p.term, p.client, p.endpoint, payload)
+		if err != nil {
+			p.upstreamError(w, r, allowed, start, err)
+			return
+		}
+		for _, req := range allowed {
+			p.logCall(r, req, start, false, callError(resp, req.Id))
+		}
+		if len(allowed) == len(reqs) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resp)
+			return
+		}
+		if err := json.Unmarshal(resp, &answers); err != nil {
+			answers = []json.RawMessage{resp}
+		}
+	}
+
+	answers = append(answers, refused...)
+	if len(answers) == 0 {
+		// only notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if batch {
+		writeJson(w, http.StatusOK, answers)
+	} else {
+		writeJson(w, http.StatusOK, answers[0])
+	}
+}
+
+// upstreamError answers every call with the error of the upstream request
+func (p *Proxy) upstreamError(w http.ResponseWriter, r *http.Request, reqs []request, start time.Time, err error) {
+	status := http.StatusBadGateway
+	rpcErr := &rpc.RpcError{Code: -32603, Message: fmt.Sprintf("upstream request failed: %v", err)}
+	var e *rpc.RpcError
+	if errors.As(err, &e) {
+		if e.HttpStatus != 0 {
+			status = e.HttpStatus
+		}
+		if e.Code != 0 {
+			rpcErr = &rpc.RpcError{Code: e.Code, Message: e.Message, Data: e.Data}
+		}
+	}
+	if r.Context().Err() != nil {
+		// the client is gone, nobody reads the answer
+		return
+	}
+	p.term.Errorf("upstream request failed: %v\n", err)
+	var resps []response
+	for _, req := range reqs {
+		p.logCall(r, req, start, false, err.Error())
+		resps = append(resps, response{Version: "2.0", Id: req.Id, Error: rpcErr})
+	}
+	if len(resps) == 1 {
+		writeJson(w, status, resps[0])
+		return
+	}
+	writeJson(w, status, resps)
+}
+
+func (p *Proxy) logCall(r *http.Request, req request, start time.Time, denied bool, errMsg string) {
+	if p.log == nil {
+		return
+	}
+	line, err := json.Marshal(logEntry{
+		Time:       start.UTC(),
+		Remote:     r.RemoteAddr,
+		Method:     req.Method,
+		Id:         req.Id,
+		DurationMs: time.Since(start).Milliseconds(),
+		Denied:     denied,
+		Error:      errMsg,
+	})
+	if err != nil {
+		return
+	}
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+	p.log.Write(append(line, '\n'))
+}
+
+// callError returns the error message of the answer with id in a single or batch response
+func callError(resp []byte, id json.RawMessage) string {
+	var answers []struct {
+		Id    json.RawMessage `json:"id"`
+		Error *rpc.RpcError   `json:"error"`
+	}
+	resp = bytes.TrimSpace(resp)
+	if len(resp) > 0 && resp[0] != '[' {
+		resp = append(append([]byte{'['}, resp...), ']')
+	}
+	if json.Unmarshal(resp, &answers) != nil {
+		return ""
+	}
+	for _, answer := range answers {
+		if string(answer.Id) == string(id) && answer.Error != nil {
+			return answer.Error.Message
+		}
+	}
+	return ""
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
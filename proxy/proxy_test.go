@@ -0,0 +1,207 @@
+package proxy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/proxy"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// startProxy serves a proxy with config in front of a fake node
+func startProxy(t *testing.T, config proxy.Config) (*httptest.Server, *rpctest.Server) {
+	upstream := rpctest.NewServer()
+	t.Cleanup(upstream.Close)
+	term := rpctest.NewScreen("")
+	// no retries, a failing upstream fails the request at once
+	client := httpclient.New(term, 1)
+	server := httptest.NewServer(proxy.New(term, client, upstream.Endpoint(), config))
+	t.Cleanup(server.Close)
+	return server, upstream
+}
+
+func post(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(out))
+}
+
+func TestProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   proxy.Config
+		body     string
+		status   int
+		want     string
+		upstream []string
+	}{
+		{
+			name:     "passes a call on",
+			body:     `{"jsonrpc":"2.0","id":"a","method":"eth_chainId"}`,
+			status:   http.StatusOK,
+			want:     `{"jsonrpc":"2.0","id":"a","result":"0x539"}`,
+			upstream: []string{"eth_chainId"},
+		},
+		{
+			name:     "passes a batch on",
+			body:     `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}]`,
+			status:   http.StatusOK,
+			want:     `[{"jsonrpc":"2.0","id":1,"result":"0x539"},{"jsonrpc":"2.0","id":1,"result":"0x1"}]`,
+			upstream: []string{"eth_chainId", "eth_blockNumber"},
+		},
+		{
+			name:   "refuses denied methods",
+			config: proxy.Config{Deny: []string{"eth_chainId"}},
+			body:   `{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}`,
+			status: http.StatusOK,
+			want:   `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"the method eth_chainId is not allowed by this proxy"}}`,
+		},
+		{
+			name:     "refuses methods that are not allowed",
+			config:   proxy.Config{Allow: []string{"eth_chainId"}},
+			body:     `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`,
+			status:   http.StatusOK,
+			want:     `[{"jsonrpc":"2.0","id":1,"result":"0x539"},{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"the method eth_blockNumber is not allowed by this proxy"}}]`,
+			upstream: []string{"eth_chainId"},
+		},
+		{
+			name:   "never proxies subscriptions",
+			body:   `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["newHeads"]}`,
+			status: http.StatusOK,
+			want:   `{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"the method eth_subscribe is not allowed by this proxy"}}`,
+		},
+		{
+			name:   "answers nothing to notifications",
+			config: proxy.Config{Deny: []string{"eth_chainId"}},
+			body:   `{"jsonrpc":"2.0","method":"eth_chainId"}`,
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "answers a parse error",
+			body:   `{"jsonrpc":`,
+			status: http.StatusOK,
+			want:   `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, upstream := startProxy(t, test.config)
+			status, body := post(t, server.URL, test.body)
+			if status != test.status {
+				t.Errorf("status %d, want %d", status, test.status)
+			}
+			if body != test.want {
+				t.Errorf("got  %s\nwant %s", body, test.want)
+			}
+			if got := upstream.Requests(); !reflect.DeepEqual(got, test.upstream) && len(got)+len(test.upstream) > 0 {
+				t.Errorf("upstream got %v, want %v", got, test.upstream)
+			}
+		})
+	}
+}
+
+func TestProxyReadOnly(t *testing.T) {
+	server, upstream := startProxy(t, proxy.Config{ReadOnly: true})
+	for _, method := range []string{
+		"eth_sendRawTransaction",
+		"eth_sendTransaction",
+		"eth_sign",
+		"eth_signTransaction",
+		"personal_unlockAccount",
+		"admin_addPeer",
+		"miner_start",
+		"debug_setHead",
+		"made_up_method",
+	} {
+		_, body := post(t, server.URL, `{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[]}`)
+		if !strings.Contains(body, "not allowed by this proxy") {
+			t.Errorf("%s was not refused: %s", method, body)
+		}
+	}
+	if _, body := post(t, server.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`); body != `{"jsonrpc":"2.0","id":1,"result":"0x1"}` {
+		t.Errorf("eth_blockNumber was not passed on: %s", body)
+	}
+	if got := upstream.Requests(); !reflect.DeepEqual(got, []string{"eth_blockNumber"}) {
+		t.Errorf("upstream got %v, want only eth_blockNumber", got)
+	}
+}
+
+func TestProxyUpstreamFailure(t *testing.T) {
+	server, upstream := startProxy(t, proxy.Config{})
+	upstream.FailNextHttp(http.StatusServiceUnavailable)
+	status, body := post(t, server.URL, `{"jsonrpc":"2.0","id":"x","method":"eth_chainId"}`)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	var resp struct {
+		Id    string `json:"id"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if resp.Id != "x" || resp.Error.Message == "" {
+		t.Errorf("got %s, want an error for id x", body)
+	}
+}
+
+func TestProxyHttp(t *testing.T) {
+	server, _ := startProxy(t, proxy.Config{})
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("GET got status %d, allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	defer func(n int64) { proxy.MaxRequestSize = n }(proxy.MaxRequestSize)
+	proxy.MaxRequestSize = 100
+	if status, _ := post(t, server.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":["`+strings.Repeat("0", 100)+`"]}`); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("large request got status %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestProxyLog(t *testing.T) {
+	var log bytes.Buffer
+	server, _ := startProxy(t, proxy.Config{Deny: []string{"eth_blockNumber"}, Log: &log})
+	post(t, server.URL, `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`)
+
+	type logEntry struct {
+		Method string `json:"method"`
+		Denied bool   `json:"denied"`
+	}
+	var entries []logEntry
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("logged %d calls, want 2:\n%s", len(entries), log.String())
+	}
+	// refused calls are logged first, they are answered before the upstream request
+	if entries[0].Method != "eth_blockNumber" || !entries[0].Denied || entries[1].Method != "eth_chainId" || entries[1].Denied {
+		t.Errorf("unexpected log:\n%s", log.String())
+	}
+}
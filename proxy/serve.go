@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaanek/jeth/flags"
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/ui"
+	"github.com/urfave/cli"
+)
+
+func ServeCommand(c context.Context, term ui.Screen, ctx *cli.Context, endpoint rpc.Endpoint) error {
+	config := Config{
+		Allow:    splitMethods(ctx.StringSlice(flags.AllowMethods.Name)),
+		Deny:     splitMethods(ctx.StringSlice(flags.DenyMethods.Name)),
+		ReadOnly: ctx.Bool(flags.ReadOnly.Name),
+	}
+	if ctx.IsSet(flags.LogFile.Name) {
+		f, err := os.OpenFile(ctx.String(flags.LogFile.Name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		config.Log = f
+	}
+	client := httpclient.NewDefault(term)
+	return Serve(c, term, ctx.String(flags.ListenAddr.Name), New(term, client, endpoint, config))
+}
+
+// Serve runs the proxy on addr until ctx is done
+func Serve(ctx context.Context, term ui.Screen, addr string, proxy *Proxy) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:     proxy,
+		ReadTimeout: time.Minute,
+	}
+	term.Print(fmt.Sprintf("Serving json-rpc on http://%s", listener.Addr()))
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(listener)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// splitMethods accepts repeated flags as well as comma separated lists
+func splitMethods(values []string) []string {
+	var methods []string
+	for _, value := range values {
+		for _, method := range strings.Split(value, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common"
+)
+
+var record = flag.Bool("record", false, "record the cassettes in testdata again from the fake node")
+
+// withFlags inserts flags after the command name of args
+func withFlags(args []string, flags ...string) []string {
+	return append(append([]string{args[0]}, flags...), args[1:]...)
+}
+
+// TestReplay runs commands offline against the cassettes in testdata. Run it with -record
+// to record them again after changing the requests of a command. The cassettes are
+// synthetic, they are recorded from the fake node of rpctest and not from a real node, so
+// they pin the requests of the commands but not the answers of a real client.
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		cassette string
+		setup    func(s *rpctest.Server)
+		args     []string
+		want     string
+		err      string
+	}{
+		{
+			name:     "tx-params",
+			cassette: "tx-params.json",
+			setup: func(s *rpctest.Server) {
+				s.SetBalance(alice, uint256.NewInt(2000000000000000000))
+				s.SetNonce(alice, 3, 4)
+				s.SetGasEstimate(51234)
+			},
+			args: []string{"tx-params", "--from", alice.Hex(), "--to", token.Hex(), "transfer(address,uint256)", alice.Hex(), "5"},
+			want: `{"rpcUrl":"testdata/tx-params.json","chainId":"0x539","from":"0x00000000000000000000000000000000000A11cE","to":"0x0000000000000000000000000000000000070C3e","value":"0x0","data":"0xa9059cbb00000000000000000000000000000000000000000000000000000000000a11ce0000000000000000000000000000000000000000000000000000000000000005","method":"transfer(address,uint256)","gasTip":"0x3b9aca00","gasPrice":"0x3b9aca00","gas":"51234","txCount":"3","txCountPending":"4","balance":"0x1bc16d674ec80000"}` + "\n",
+		},
+		{
+			name:     "tx-params with other arguments",
+			cassette: "tx-params.json",
+			args:     []string{"tx-params", "--from", alice.Hex(), "--to", token.Hex(), "transfer(address,uint256)", alice.Hex(), "6"},
+			err:      "request not found in cassette: eth_estimateGas",
+		},
+		{
+			name:     "call",
+			cassette: "call.json",
+			setup: func(s *rpctest.Server) {
+				s.SetCallResult(token, balanceOfAlice, common.LeftPadBytes([]byte{42}, 32))
+			},
+			args: []string{"call", "--to", token.Hex(), "--out", "uint256", "balanceOf(address)", alice.Hex()},
+			want: `{"result":"0x000000000000000000000000000000000000000000000000000000000000002a","unpacked":[{"type":"uint256","value":42}]}` + "\n",
+		},
+		{
+			name:     "call reverts",
+			cassette: "call-revert.json",
+			setup: func(s *rpctest.Server) {
+				s.SetCallRevert(token, nil, "not allowed")
+			},
+			args: []string{"call", "--to", token.Hex(), "balanceOf(address)", alice.Hex()},
+			err:  "(reason: not allowed)",
+		},
+		{
+			name:     "receipt",
+			cassette: "receipt.json",
+			setup: func(s *rpctest.Server) {
+				s.AddReceipt(txHash, map[string]interface{}{
+					"blockHash":         "0x2222222222222222222222222222222222222222222222222222222222222222",
+					"blockNumber":       "0x2",
+					"contractAddress":   nil,
+					"cumulativeGasUsed": "0xa410",
+					"effectiveGasPrice": "0x3b9aca00",
+					"from":              alice.Hex(),
+					"gasUsed":           "0x5208",
+					"logs":              []interface{}{},
+					"logsBloom":         "0x" + strings.Repeat("00", 256),
+					"status":            "0x1",
+					"to":                token.Hex(),
+					"transactionHash":   txHash.Hex(),
+					"transactionIndex":  "0x1",
+					"type":              "0x2",
+				}, 0)
+			},
+			args: []string{"receipt", "--param", txHash.Hex()},
+			want: "blockHash: 0x2222222222222222222222222222222222222222222222222222222222222222\n" +
+				"blockNumber: 0x2\n" +
+				"contractAddress: \n" +
+				"cumulativeGasUsed: 0xa410\n" +
+				"effectiveGasPrice: 0x3b9aca00\n" +
+				"from: 0x00000000000000000000000000000000000A11cE\n" +
+				"gasUsed: 0x5208\n" +
+				"logs: []\n" +
+				"logsBloom: 0x" + strings.Repeat("00", 256) + "\n" +
+				"status: 0x1\n" +
+				"to: 0x0000000000000000000000000000000000070C3e\n" +
+				"transactionHash: " + txHash.Hex() + "\n" +
+				"transactionIndex: 0x1\n" +
+				"type: 0x2\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join("testdata", test.cassette)
+			if *record && test.setup != nil {
+				server := rpctest.NewServer()
+				defer server.Close()
+				test.setup(server)
+				runApp(t, withFlags(test.args, "--rpc.url", server.URL, "--rpc.record", path)...)
+			}
+			term := runApp(t, withFlags(test.args, "--rpc.replay", path)...)
+
+			errs := term.Errors()
+			if test.err != "" {
+				if len(errs) != 1 || !strings.Contains(errs[0], test.err) {
+					t.Fatalf("got errors %q, want one with %q", errs, test.err)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors %q", errs)
+			}
+			if term.Stdout() != test.want {
+				t.Errorf("got  %s\nwant %s", term.Stdout(), test.want)
+			}
+		})
+	}
+}
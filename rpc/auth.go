@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+// JwtTokenLifetime is how long a signed token is reused. Nodes reject tokens whose iat
+// claim is too far from their clock, so it is kept short.
+var JwtTokenLifetime = 5 * time.Second
+
+// Authorizer is implemented by endpoints that add credentials to each request
+type Authorizer interface {
+	Authorize(header http.Header) error
+}
+
+type EndpointOption func(*endpointOptions)
+
+type endpointOptions struct {
+	auth        endpointAuth
+	transport   *http.Transport
+	timeout     time.Duration
+	gzipMinSize int
+}
+
+func newEndpointOptions(opts []EndpointOption) *endpointOptions {
+	o := &endpointOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHeader sets a static header on every request, e.g. an api key
+func WithHeader(name, value string) EndpointOption {
+	return func(o *endpointOptions) {
+		if o.auth.header == nil {
+			o.auth.header = http.Header{}
+		}
+		o.auth.header.Add(name, value)
+	}
+}
+
+// WithJwtSecret signs every request with a HS256 bearer token, as the authenticated ports
+// of erigon and geth expect
+func WithJwtSecret(secret []byte) EndpointOption {
+	return func(o *endpointOptions) {
+		o.auth.jwt = &jwtAuth{secret: secret}
+	}
+}
+
+// WithTransport makes the connections of the endpoint with t, see httpclient.NewTransport.
+// Websocket endpoints use its dialer and tls settings.
+func WithTransport(t *http.Transport) EndpointOption {
+	return func(o *endpointOptions) {
+		o.transport = t
+	}
+}
+
+// WithGzipRequests gzips request bodies of at least minSize bytes, for endpoints that
+// accept compressed requests
+func WithGzipRequests(minSize int) EndpointOption {
+	return func(o *endpointOptions) {
+		o.gzipMinSize = minSize
+	}
+}
+
+// WithTimeout limits each request to the endpoint instead of httpclient.DefaultTimeout,
+// over websocket and ipc too
+func WithTimeout(d time.Duration) EndpointOption {
+	return func(o *endpointOptions) {
+		o.timeout = d
+	}
+}
+
+// ReadJwtSecret reads a hex encoded secret file as written by geth and erigon
+func ReadJwtSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	str := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(str, "0x") {
+		str = "0x" + str
+	}
+	secret, err := hexutil.Decode(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt secret in %s: %w", path, err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("invalid jwt secret in %s: expected 32 bytes, got %d", path, len(secret))
+	}
+	return secret, nil
+}
+
+type endpointAuth struct {
+	header http.Header
+	jwt    *jwtAuth
+}
+
+func (a *endpointAuth) Authorize(header http.Header) error {
+	for name, values := range a.header {
+		header[name] = values
+	}
+	if a.jwt != nil {
+		token, err := a.jwt.token()
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+type jwtAuth struct {
+	secret []byte
+
+	mu     sync.Mutex
+	signed string
+	issued time.Time
+}
+
+// token returns the current token or signs a new one when it is older than JwtTokenLifetime
+func (j *jwtAuth) token() (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	if j.signed != "" && now.Sub(j.issued) < JwtTokenLifetime {
+		return j.signed, nil
+	}
+	if len(j.secret) == 0 {
+		return "", errors.New("empty jwt secret")
+	}
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{"iat": now.Unix()})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + enc.EncodeToString(claims)
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(unsigned))
+	j.signed = unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+	j.issued = now
+	return j.signed, nil
+}
+
+// RedactUrl hides the password of a url so it can be logged
+func RedactUrl(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/metrics"
+	"github.com/jaanek/jeth/ui"
+)
+
+var ErrNoBatchResponse = errors.New("no response in batch for request")
+
+// BatchElem is a single request in a batch call. Result is either an RpcResponse that
+// receives the whole response or a pointer that the result gets decoded into. Error is set
+// when this particular request failed.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  error
+}
+
+// BatchCall sends all requests in one round trip. Responses are matched back to
+// requests by id. The returned error is only set when the whole batch failed, per request
+// errors are reported in BatchElem.Error.
+func BatchCall(ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, batch []BatchElem) error {
+	return BatchCallContext(context.Background(), ui, client, endpoint, batch)
+}
+
+func BatchCallContext(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, batch []BatchElem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	firstId := nextIds(len(batch))
+	reqs := make([]RpcRequest, len(batch))
+	for i, elem := range batch {
+		reqs[i] = RpcRequest{
+			Id:      firstId + uint(i),
+			Version: "2.0",
+			Method:  elem.Method,
+			Params:  elem.Params,
+		}
+	}
+	payload, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+	ui.Log(string(payload))
+	start := time.Now()
+	body, err := roundTrip(ctx, ui, client, endpoint, payload)
+	metrics.RpcCall("batch", time.Since(start), errorCode(err), len(payload), len(body))
+	if err != nil {
+		return err
+	}
+	ui.Log(string(body))
+	var msgs []json.RawMessage
+	if err := json.Unmarshal(body, &msgs); err != nil {
+		// a node might reply with a single error object to the whole batch
+		single := RpcResultStr{}
+		if json.Unmarshal(body, &single) == nil && single.Err != nil {
+			return single.Err
+		}
+		return err
+	}
+	answered := make([]bool, len(batch))
+	for _, msg := range msgs {
+		var head struct {
+			Id uint `json:"id"`
+		}
+		if err := json.Unmarshal(msg, &head); err != nil {
+			return err
+		}
+		if head.Id < firstId || head.Id-firstId >= uint(len(batch)) {
+			return fmt.Errorf("batch response with unknown id: %d", head.Id)
+		}
+		i := head.Id - firstId
+		if answered[i] {
+			return fmt.Errorf("batch response with duplicate id: %d", head.Id)
+		}
+		answered[i] = true
+		batch[i].Error = withMethod(decodeResponse(msg, batch[i].Result), batch[i].Method)
+		if batch[i].Error != nil && !errors.Is(batch[i].Error, ErrNullResult) {
+			metrics.RpcError(batch[i].Method, errorCode(batch[i].Error))
+		}
+	}
+	for i := range batch {
+		if !answered[i] {
+			batch[i].Error = fmt.Errorf("%w: %s", ErrNoBatchResponse, batch[i].Method)
+		}
+	}
+	return nil
+}
+
+func decodeResponse(msg []byte, result interface{}) error {
+	if resp, ok := result.(RpcResponse); ok {
+		if err := json.Unmarshal(msg, resp); err != nil {
+			return err
+		}
+		if resp.Error() != nil {
+			return resp.Error()
+		}
+		return nil
+	}
+	resp := RpcResultRaw{}
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		return err
+	}
+	if resp.Err != nil {
+		return resp.Err
+	}
+	return resp.Decode(result)
+}
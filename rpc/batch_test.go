@@ -0,0 +1,136 @@
+package rpc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// batchServer answers a batch with the body that answer returns for the ids of its requests
+func batchServer(t *testing.T, answer func(ids []string) string) rpc.Endpoint {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var reqs []struct {
+			Id json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			t.Errorf("not a batch: %s", body)
+		}
+		var ids []string
+		for _, req := range reqs {
+			ids = append(ids, string(req.Id))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, answer(ids))
+	}))
+	t.Cleanup(server.Close)
+	return rpc.NewEndpoint(server.URL)
+}
+
+func result(id, value string) string {
+	return `{"jsonrpc":"2.0","id":` + id + `,"result":"` + value + `"}`
+}
+
+func TestBatchCall(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(ids []string) string
+		// err is part of the error of the whole batch
+		err     string
+		results []string
+		errs    []error
+	}{
+		{
+			name: "in order",
+			answer: func(ids []string) string {
+				return "[" + result(ids[0], "0x1") + "," + result(ids[1], "0x2") + "]"
+			},
+			results: []string{"0x1", "0x2"},
+			errs:    []error{nil, nil},
+		},
+		{
+			name: "out of order",
+			answer: func(ids []string) string {
+				return "[" + result(ids[1], "0x2") + "," + result(ids[0], "0x1") + "]"
+			},
+			results: []string{"0x1", "0x2"},
+			errs:    []error{nil, nil},
+		},
+		{
+			name: "a request without an answer",
+			answer: func(ids []string) string {
+				return "[" + result(ids[0], "0x1") + "]"
+			},
+			results: []string{"0x1", ""},
+			errs:    []error{nil, rpc.ErrNoBatchResponse},
+		},
+		{
+			name: "an error answering the request",
+			answer: func(ids []string) string {
+				return "[" + result(ids[0], "0x1") + `,{"jsonrpc":"2.0","id":` + ids[1] + `,"error":{"code":-32601,"message":"no such method"}}]`
+			},
+			results: []string{"0x1", ""},
+			errs:    []error{nil, rpc.ErrMethodNotFound},
+		},
+		{
+			name: "one error answering the whole batch",
+			answer: func(ids []string) string {
+				return `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch too large"}}`
+			},
+			err: "batch too large",
+		},
+		{
+			name: "duplicate ids",
+			answer: func(ids []string) string {
+				return "[" + result(ids[0], "0x1") + "," + result(ids[0], "0x2") + "]"
+			},
+			err: "batch response with duplicate id",
+		},
+		{
+			name: "unknown id",
+			answer: func(ids []string) string {
+				return "[" + result(ids[0], "0x1") + "," + result("4000000000", "0x2") + "]"
+			},
+			err: "batch response with unknown id: 4000000000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := batchServer(t, test.answer)
+			term := rpctest.NewScreen("")
+			results := make([]string, 2)
+			batch := []rpc.BatchElem{
+				{Method: "eth_chainId", Result: &results[0]},
+				{Method: "eth_blockNumber", Result: &results[1]},
+			}
+			err := rpc.BatchCall(term, httpclient.New(term, 1), endpoint, batch)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want one with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, elem := range batch {
+				if results[i] != test.results[i] {
+					t.Errorf("%s: got result %q, want %q", elem.Method, results[i], test.results[i])
+				}
+				want := test.errs[i]
+				if want == nil && elem.Error != nil || want != nil && !errors.Is(elem.Error, want) {
+					t.Errorf("%s: got error %v, want %v", elem.Method, elem.Error, want)
+				}
+			}
+		})
+	}
+}
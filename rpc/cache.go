@@ -0,0 +1,453 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+var (
+	// FinalityDepth is how many blocks below the head a block is treated as final and its
+	// queries are cached forever
+	FinalityDepth = uint64(64)
+	// ChainIdCacheTTL is how long the chain id of an endpoint is cached, a local dev node
+	// can be restarted on another chain
+	ChainIdCacheTTL = time.Hour
+	// headRefresh is how often the head is fetched again to decide about finality
+	headRefresh = 12 * time.Second
+)
+
+// CacheStore keeps cached results. A zero ttl keeps the value forever.
+type CacheStore interface {
+	Get(key string) (json.RawMessage, bool)
+	Put(key string, value json.RawMessage, ttl time.Duration) error
+}
+
+type cacheEntry struct {
+	Expires time.Time       `json:"expires,omitempty"`
+	Result  json.RawMessage `json:"result"`
+}
+
+func (c cacheEntry) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.After(c.Expires)
+}
+
+func newCacheEntry(value json.RawMessage, ttl time.Duration, now time.Time) cacheEntry {
+	entry := cacheEntry{Result: value}
+	if ttl > 0 {
+		entry.Expires = now.Add(ttl)
+	}
+	return entry
+}
+
+type memoryCache struct {
+	clock httpclient.Clock
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewMemoryCache returns a store that lives as long as the process
+func NewMemoryCache() CacheStore {
+	return &memoryCache{clock: httpclient.DefaultClock, entries: map[string]cacheEntry{}}
+}
+
+func (c *memoryCache) Get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.expired(c.clock.Now()) {
+		return nil, false
+	}
+	return entry.Result, true
+}
+
+func (c *memoryCache) Put(key string, value json.RawMessage, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = newCacheEntry(value, ttl, c.clock.Now())
+	return nil
+}
+
+type diskCache struct {
+	dir   string
+	clock httpclient.Clock
+}
+
+// NewDiskCache returns a store that keeps a file per entry in dir, so it is shared by runs
+func NewDiskCache(dir string) (CacheStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, clock: httpclient.DefaultClock}, nil
+}
+
+// DefaultCacheDir is the disk cache directory in the user cache dir
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "jeth", "rpc")
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *diskCache) Get(key string) (json.RawMessage, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.expired(c.clock.Now()) {
+		return nil, false
+	}
+	return entry.Result, true
+}
+
+func (c *diskCache) Put(key string, value json.RawMessage, ttl time.Duration) error {
+	data, err := json.Marshal(newCacheEntry(value, ttl, c.clock.Now()))
+	if err != nil {
+		return err
+	}
+	// write to a temp file first so concurrent runs never read half an entry
+	f, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+type cachingEndpoint struct {
+	endpoint Endpoint
+	store    CacheStore
+	clock    httpclient.Clock
+
+	mu      sync.Mutex
+	head    uint64
+	headAt  time.Time
+	fetched bool
+}
+
+// NewCachingEndpoint answers queries that cannot change from store and passes everything
+// else on to endpoint. The chain id is cached for ChainIdCacheTTL. Queries by block hash,
+// and by block number at least FinalityDepth blocks below the head, are cached forever.
+// Queries for "latest", "pending" and other tags are never cached, nor are errors and null
+// results.
+func NewCachingEndpoint(endpoint Endpoint, store CacheStore) Endpoint {
+	return &cachingEndpoint{endpoint: endpoint, store: store, clock: httpclient.DefaultClock}
+}
+
+func (e *cachingEndpoint) Url() string {
+	return e.endpoint.Url()
+}
+
+func (e *cachingEndpoint) Close() error {
+	if c, ok := e.endpoint.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// cacheRule tells whether and how long the answer to a request can be cached
+type cacheRule struct {
+	ttl time.Duration
+	// the answer is final when this block is, zero when it is known to be final
+	block uint64
+	// the answer is final when the block of the transaction in it is
+	txBlock bool
+}
+
+// position of the block parameter of methods that take a block number or tag
+var blockParamIndex = map[string]int{
+	"eth_getBlockByNumber":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleByBlockNumberAndIndex":       0,
+	"eth_getBalance":                          1,
+	"eth_getCode":                             1,
+	"eth_getTransactionCount":                 1,
+	"eth_call":                                1,
+	"eth_estimateGas":                         1,
+	"eth_getStorageAt":                        2,
+	"eth_getProof":                            2,
+}
+
+var blockHashMethods = map[string]bool{
+	"eth_getBlockByHash":                    true,
+	"eth_getBlockTransactionCountByHash":    true,
+	"eth_getUncleCountByBlockHash":          true,
+	"eth_getTransactionByBlockHashAndIndex": true,
+	"eth_getUncleByBlockHashAndIndex":       true,
+}
+
+func ruleFor(method string, params json.RawMessage) (cacheRule, bool) {
+	switch method {
+	case "eth_chainId", "net_version":
+		return cacheRule{ttl: ChainIdCacheTTL}, true
+	case "eth_getTransactionReceipt", "eth_getTransactionByHash":
+		return cacheRule{txBlock: true}, true
+	}
+	if blockHashMethods[method] {
+		return cacheRule{}, true
+	}
+	i, ok := blockParamIndex[method]
+	if !ok {
+		return cacheRule{}, false
+	}
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || i >= len(args) {
+		// no block parameter means latest
+		return cacheRule{}, false
+	}
+	return blockRule(args[i])
+}
+
+// blockRule handles a block number, a tag or an eip-1898 block object
+func blockRule(param json.RawMessage) (cacheRule, bool) {
+	var str string
+	if err := json.Unmarshal(param, &str); err == nil {
+		number, err := hexutil.DecodeUint64(str)
+		if err != nil {
+			// latest, pending, safe, finalized and earliest
+			return cacheRule{}, false
+		}
+		return cacheRule{block: number}, true
+	}
+	var obj struct {
+		BlockHash   *string         `json:"blockHash"`
+		BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(param, &obj); err != nil {
+		return cacheRule{}, false
+	}
+	if obj.BlockHash != nil {
+		return cacheRule{}, true
+	}
+	if obj.BlockNumber != nil {
+		return cacheRule{block: uint64(*obj.BlockNumber)}, true
+	}
+	return cacheRule{}, false
+}
+
+func (e *cachingEndpoint) key(method string, params json.RawMessage) (string, error) {
+	canonical, err := canonicalParams(params)
+	if err != nil {
+		return "", err
+	}
+	return e.endpoint.Url() + "|" + method + "|" + string(canonical), nil
+}
+
+func (e *cachingEndpoint) Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error) {
+	var reqs []rawRequest
+	if err := unmarshalMessages(payload, &reqs); err != nil {
+		return send(e.endpoint, payload)
+	}
+	batch := len(bytes.TrimSpace(payload)) > 0 && bytes.TrimSpace(payload)[0] == '['
+
+	hits := map[int]json.RawMessage{}
+	keys := map[int]string{}
+	rules := map[int]cacheRule{}
+	var misses []rawRequest
+	for i, req := range reqs {
+		if rule, ok := ruleFor(req.Method, req.Params); ok {
+			if key, err := e.key(req.Method, req.Params); err == nil {
+				if result, ok := e.store.Get(key); ok {
+					hits[i] = result
+					continue
+				}
+				keys[i] = key
+				rules[i] = rule
+			}
+		}
+		misses = append(misses, req)
+	}
+	if len(hits) > 0 {
+		ui.Logf("answered %d of %d calls from cache\n", len(hits), len(reqs))
+	}
+
+	var body []byte
+	var received []json.RawMessage
+	if len(misses) > 0 {
+		missPayload := payload
+		if len(hits) > 0 {
+			var err error
+			if missPayload, err = json.Marshal(misses); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		body, err = send(e.endpoint, missPayload)
+		if err != nil {
+			return nil, err
+		}
+		if err := unmarshalMessages(body, &received); err != nil {
+			return body, nil
+		}
+		e.save(ctx, ui, send, reqs, keys, rules, received)
+	}
+	if len(hits) == 0 {
+		return body, nil
+	}
+
+	byId := map[string]json.RawMessage{}
+	for _, msg := range received {
+		var head struct {
+			Id json.RawMessage `json:"id"`
+		}
+		if json.Unmarshal(msg, &head) == nil {
+			byId[string(head.Id)] = msg
+		}
+	}
+	resps := make([]json.RawMessage, 0, len(reqs))
+	for i, req := range reqs {
+		if result, ok := hits[i]; ok {
+			msg, err := json.Marshal(rawResponse{Version: "2.0", Id: req.Id, Result: result})
+			if err != nil {
+				return nil, err
+			}
+			resps = append(resps, msg)
+		} else if msg, ok := byId[string(req.Id)]; ok {
+			resps = append(resps, msg)
+		}
+	}
+	if !batch {
+		return resps[0], nil
+	}
+	return json.Marshal(resps)
+}
+
+// save caches the answers that are final
+func (e *cachingEndpoint) save(ctx context.Context, ui ui.Screen, send func(endpoint Endpoint, payload []byte) ([]byte, error), reqs []rawRequest, keys map[int]string, rules map[int]cacheRule, received []json.RawMessage) {
+	// the head is looked up once for all the answers of a request
+	var head uint64
+	var headKnown, headLooked bool
+	isFinal := func(block uint64) bool {
+		if !headLooked {
+			head, headKnown = e.currentHead(ctx, ui, send)
+			headLooked = true
+		}
+		return headKnown && block+FinalityDepth <= head
+	}
+	byId := map[string]rawResponse{}
+	for _, msg := range received {
+		var resp rawResponse
+		if json.Unmarshal(msg, &resp) == nil {
+			byId[string(resp.Id)] = resp
+		}
+	}
+	for i, req := range reqs {
+		resp, ok := byId[string(req.Id)]
+		if !ok || resp.Error != nil {
+			continue
+		}
+		if req.Method == "eth_blockNumber" {
+			var head hexutil.Uint64
+			if json.Unmarshal(resp.Result, &head) == nil {
+				e.observeHead(uint64(head))
+			}
+		}
+		key, ok := keys[i]
+		if !ok || len(resp.Result) == 0 || string(resp.Result) == "null" {
+			continue
+		}
+		rule := rules[i]
+		if rule.txBlock {
+			var tx struct {
+				BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+			}
+			if json.Unmarshal(resp.Result, &tx) != nil || tx.BlockNumber == nil {
+				// still pending
+				continue
+			}
+			rule.block = uint64(*tx.BlockNumber)
+		}
+		if rule.block > 0 && !isFinal(rule.block) {
+			continue
+		}
+		if err := e.store.Put(key, resp.Result, rule.ttl); err != nil {
+			ui.Errorf("failed to cache %s response: %v\n", req.Method, err)
+		}
+	}
+}
+
+func (e *cachingEndpoint) observeHead(head uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if head > e.head {
+		e.head = head
+	}
+	e.headAt = e.clock.Now()
+	e.fetched = true
+}
+
+// currentHead returns the head block and whether it is known. It is fetched when it is not
+// known or older than headRefresh, a head that fails to update is still a lower bound.
+func (e *cachingEndpoint) currentHead(ctx context.Context, ui ui.Screen, send func(endpoint Endpoint, payload []byte) ([]byte, error)) (uint64, bool) {
+	e.mu.Lock()
+	head, fetched := e.head, e.fetched
+	stale := !fetched || e.clock.Now().Sub(e.headAt) > headRefresh
+	e.mu.Unlock()
+	if !stale || ctx.Err() != nil {
+		return head, fetched
+	}
+	payload, err := json.Marshal(RpcRequest{Id: nextIds(1), Version: "2.0", Method: "eth_blockNumber", Params: []interface{}{}})
+	if err != nil {
+		return head, fetched
+	}
+	body, err := send(e.endpoint, payload)
+	if err != nil {
+		ui.Logf("failed to fetch head for the cache: %v\n", err)
+		return head, fetched
+	}
+	resp := RpcResultStr{}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Err != nil {
+		return head, fetched
+	}
+	latest, err := hexutil.DecodeUint64(resp.Result)
+	if err != nil {
+		return head, fetched
+	}
+	e.observeHead(latest)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.head, true
+}
+
+// ParseCacheStore returns the store for a --rpc.cache value: "memory", "disk" for the
+// default cache dir, or a directory
+func ParseCacheStore(value string) (CacheStore, error) {
+	switch strings.ToLower(value) {
+	case "memory":
+		return NewMemoryCache(), nil
+	case "disk":
+		return NewDiskCache(DefaultCacheDir())
+	}
+	return NewDiskCache(value)
+}
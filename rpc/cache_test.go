@@ -0,0 +1,173 @@
+package rpc_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common"
+)
+
+var (
+	holder    = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	cachedTx  = common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
+	blockHash = "0x2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// withClock makes the caches created by fn tell the time by a fake clock
+func withClock(fn func()) *rpctest.Clock {
+	clock := rpctest.NewClock()
+	old := httpclient.DefaultClock
+	httpclient.DefaultClock = clock
+	defer func() { httpclient.DefaultClock = old }()
+	fn()
+	return clock
+}
+
+// countCalls makes the same call n times through endpoint and returns how many of them
+// reached server
+func countCalls(t *testing.T, server *rpctest.Server, endpoint rpc.Endpoint, n int, method string, params ...interface{}) int {
+	t.Helper()
+	term := rpctest.NewScreen("")
+	client := httpclient.New(term, 1)
+	before := countMethod(server, method)
+	for i := 0; i < n; i++ {
+		var result json.RawMessage
+		if err := rpc.CallResult(term, client, endpoint, method, params, &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return countMethod(server, method) - before
+}
+
+// countMethod returns how many requests for method reached server
+func countMethod(server *rpctest.Server, method string) int {
+	n := 0
+	for _, m := range server.Requests() {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCacheRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		params []interface{}
+		calls  int
+	}{
+		{"latest", "eth_getBalance", []interface{}{holder, "latest"}, 3},
+		{"pending", "eth_getTransactionCount", []interface{}{holder, "pending"}, 3},
+		{"no block", "eth_getCode", []interface{}{holder}, 3},
+		{"block number near the head", "eth_getBalance", []interface{}{holder, "0x11"}, 3},
+		{"final block number", "eth_getBalance", []interface{}{holder, "0x10"}, 1},
+		{"block hash", "eth_getBalance", []interface{}{holder, map[string]string{"blockHash": blockHash}}, 1},
+		{"chain id", "eth_chainId", nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := rpctest.NewServer()
+			defer server.Close()
+			server.SetBlockNumber(0x10 + rpc.FinalityDepth)
+			endpoint := rpc.NewCachingEndpoint(server.Endpoint(), rpc.NewMemoryCache())
+			if calls := countCalls(t, server, endpoint, 3, test.method, test.params...); calls != test.calls {
+				t.Errorf("%d of 3 calls reached the node, want %d", calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestCacheChainIdExpires(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	var endpoint rpc.Endpoint
+	clock := withClock(func() {
+		endpoint = rpc.NewCachingEndpoint(server.Endpoint(), rpc.NewMemoryCache())
+	})
+
+	if calls := countCalls(t, server, endpoint, 2, "eth_chainId"); calls != 1 {
+		t.Fatalf("%d calls reached the node, want 1", calls)
+	}
+	clock.Advance(rpc.ChainIdCacheTTL - time.Second)
+	if calls := countCalls(t, server, endpoint, 1, "eth_chainId"); calls != 0 {
+		t.Fatalf("chain id expired before its ttl")
+	}
+	clock.Advance(2 * time.Second)
+	if calls := countCalls(t, server, endpoint, 1, "eth_chainId"); calls != 1 {
+		t.Fatalf("chain id did not expire after its ttl")
+	}
+}
+
+func TestCacheReceiptOnceFinal(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	var endpoint rpc.Endpoint
+	clock := withClock(func() {
+		endpoint = rpc.NewCachingEndpoint(server.Endpoint(), rpc.NewMemoryCache())
+	})
+	// the fake node mines the block of the receipt when it is first asked for, the head is
+	// then block 0x10
+	server.SetBlockNumber(0xf)
+	server.AddReceipt(cachedTx, map[string]string{"transactionHash": cachedTx.Hex(), "blockNumber": "0x10"}, 0)
+
+	if calls := countCalls(t, server, endpoint, 2, "eth_getTransactionReceipt", cachedTx); calls != 2 {
+		t.Fatalf("%d of 2 calls reached the node, the receipt is cached before it is final", calls)
+	}
+	// the head is fetched once and kept for a while
+	if heads := countMethod(server, "eth_blockNumber"); heads != 1 {
+		t.Errorf("fetched the head %d times, want once", heads)
+	}
+
+	server.SetBlockNumber(0x10 + rpc.FinalityDepth)
+	if calls := countCalls(t, server, endpoint, 1, "eth_getTransactionReceipt", cachedTx); calls != 1 {
+		t.Fatal("the receipt was cached with an old head")
+	}
+	clock.Advance(time.Minute)
+	if calls := countCalls(t, server, endpoint, 2, "eth_getTransactionReceipt", cachedTx); calls != 1 {
+		t.Errorf("%d of 2 calls reached the node, want the final receipt cached", calls)
+	}
+}
+
+func TestCacheFetchesHeadOncePerBatch(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	server.SetBlockNumber(0x100)
+	endpoint := rpc.NewCachingEndpoint(server.Endpoint(), rpc.NewMemoryCache())
+
+	term := rpctest.NewScreen("")
+	var batch []rpc.BatchElem
+	for i := 0; i < 10; i++ {
+		var result json.RawMessage
+		batch = append(batch, rpc.BatchElem{Method: "eth_getBalance", Params: []interface{}{holder, "0x1"}, Result: &result})
+	}
+	server.FailNext("eth_blockNumber", &rpc.RpcError{Code: -32000, Message: "no head"})
+	if err := rpc.BatchCall(term, httpclient.New(term, 1), endpoint, batch); err != nil {
+		t.Fatal(err)
+	}
+	if heads := countMethod(server, "eth_blockNumber"); heads != 1 {
+		t.Errorf("fetched the head %d times for one batch, want once", heads)
+	}
+}
+
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	dir := t.TempDir()
+
+	for run := 0; run < 2; run++ {
+		store, err := rpc.NewDiskCache(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint := rpc.NewCachingEndpoint(server.Endpoint(), store)
+		want := 1 - run
+		if calls := countCalls(t, server, endpoint, 2, "eth_chainId"); calls != want {
+			t.Errorf("run %d: %d calls reached the node, want %d", run+1, calls, want)
+		}
+	}
+}
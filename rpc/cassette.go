@@ -0,0 +1,250 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jaanek/jeth/ui"
+)
+
+var ErrNotRecorded = errors.New("request not found in cassette")
+
+// Cassette holds recorded json-rpc exchanges. Request ids are not stored, a request is
+// matched on its method and params.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RpcError       `json:"error,omitempty"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type recordingEndpoint struct {
+	endpoint Endpoint
+	path     string
+	tmp      *os.File
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingEndpoint passes requests on to endpoint and records every answered request.
+// Close writes the cassette to path, which can be served back with NewReplayEndpoint, so
+// a cassette at path stays as it is until the recording is complete. Requests that fail
+// without a json-rpc response are not recorded.
+func NewRecordingEndpoint(endpoint Endpoint, path string) (Endpoint, error) {
+	// the temp file is renamed to path, it has to be in the same directory
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	return &recordingEndpoint{endpoint: endpoint, path: path, tmp: tmp}, nil
+}
+
+func (e *recordingEndpoint) Url() string {
+	return e.endpoint.Url()
+}
+
+func (e *recordingEndpoint) Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error) {
+	body, err := send(e.endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.record(payload, body); err != nil {
+		ui.Errorf("failed to record rpc response: %v\n", err)
+	}
+	return body, nil
+}
+
+func (e *recordingEndpoint) record(payload, body []byte) error {
+	var reqs []rawRequest
+	if err := unmarshalMessages(payload, &reqs); err != nil {
+		return err
+	}
+	var resps []rawResponse
+	if err := unmarshalMessages(body, &resps); err != nil {
+		return err
+	}
+	byId := make(map[string]rawResponse, len(resps))
+	for _, resp := range resps {
+		byId[string(resp.Id)] = resp
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, req := range reqs {
+		resp, ok := byId[string(req.Id)]
+		if !ok {
+			continue
+		}
+		params, err := canonicalParams(req.Params)
+		if err != nil {
+			return err
+		}
+		e.cassette.Interactions = append(e.cassette.Interactions, Interaction{
+			Method: req.Method,
+			Params: params,
+			Result: resp.Result,
+			Error:  resp.Error,
+		})
+	}
+	return nil
+}
+
+// save writes the cassette to the temp file and renames it to path
+func (e *recordingEndpoint) save() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.tmp == nil {
+		return nil
+	}
+	tmp := e.tmp
+	e.tmp = nil
+	data, err := json.MarshalIndent(&e.cassette, "", "  ")
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save cassette %s: %w", e.path, err)
+	}
+	return os.Rename(tmp.Name(), e.path)
+}
+
+func (e *recordingEndpoint) Close() error {
+	err := e.save()
+	if c, ok := e.endpoint.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type replayEndpoint struct {
+	path string
+
+	mu     sync.Mutex
+	tapes  map[string][]Interaction
+	played map[string]int
+}
+
+// NewReplayEndpoint serves the responses of a recorded cassette without a node. Repeated
+// requests get the recorded responses in order, the last one is repeated once they run out.
+func NewReplayEndpoint(path string) (Endpoint, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &replayEndpoint{
+		path:   path,
+		tapes:  map[string][]Interaction{},
+		played: map[string]int{},
+	}
+	for _, in := range cassette.Interactions {
+		params, err := canonicalParams(in.Params)
+		if err != nil {
+			return nil, err
+		}
+		key := in.Method + string(params)
+		e.tapes[key] = append(e.tapes[key], in)
+	}
+	return e, nil
+}
+
+func (e *replayEndpoint) Url() string {
+	return e.path
+}
+
+func (e *replayEndpoint) RoundTrip(ctx context.Context, payload []byte) ([]byte, error) {
+	var reqs []rawRequest
+	if err := unmarshalMessages(payload, &reqs); err != nil {
+		return nil, err
+	}
+	resps := make([]rawResponse, 0, len(reqs))
+	for _, req := range reqs {
+		in, err := e.play(req)
+		if err != nil {
+			return nil, err
+		}
+		resp := rawResponse{Version: "2.0", Id: req.Id, Result: in.Result, Error: in.Error}
+		if resp.Result == nil && resp.Error == nil {
+			resp.Result = json.RawMessage("null")
+		}
+		resps = append(resps, resp)
+	}
+	if len(payload) > 0 && payload[0] == '[' {
+		return json.Marshal(resps)
+	}
+	return json.Marshal(resps[0])
+}
+
+func (e *replayEndpoint) play(req rawRequest) (Interaction, error) {
+	params, err := canonicalParams(req.Params)
+	if err != nil {
+		return Interaction{}, err
+	}
+	key := req.Method + string(params)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tape := e.tapes[key]
+	if len(tape) == 0 {
+		return Interaction{}, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, params)
+	}
+	i := e.played[key]
+	if i >= len(tape) {
+		i = len(tape) - 1
+	}
+	e.played[key] = i + 1
+	return tape[i], nil
+}
+
+// canonicalParams re-encodes params so that formatting and key order do not affect matching
+func canonicalParams(params json.RawMessage) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(params)) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
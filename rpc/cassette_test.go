@@ -0,0 +1,66 @@
+package rpc_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+func TestRecordingKeepsCassetteUntilClose(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	old := []byte(`{"interactions":[]}`)
+	if err := os.WriteFile(path, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := rpc.NewRecordingEndpoint(server.Endpoint(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		var chainId hexutil.Uint64
+		if err := rpc.CallResult(term, client, endpoint, "eth_chainId", nil, &chainId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, old) {
+		t.Fatalf("cassette changed before close: %s %v", data, err)
+	}
+
+	if err := endpoint.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	cassette, err := rpc.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cassette.Interactions); n != 2 {
+		t.Fatalf("recorded %d interactions, want 2", n)
+	}
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp-*")); len(files) > 0 {
+		t.Errorf("temp files left behind: %v", files)
+	}
+
+	replay, err := rpc.NewReplayEndpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chainId hexutil.Uint64
+	if err := rpc.CallResult(term, client, replay, "eth_chainId", nil, &chainId); err != nil {
+		t.Fatal(err)
+	}
+	if chainId != 1337 {
+		t.Errorf("replayed chain id %d, want 1337", chainId)
+	}
+}
@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+var ErrWrongChain = errors.New("wrong chain")
+
+type chainCheckEndpoint struct {
+	endpoint Endpoint
+	chainId  uint64
+
+	mu  sync.Mutex
+	ok  bool
+	err error
+}
+
+// NewChainCheckEndpoint refuses requests that change state, e.g. eth_sendRawTransaction,
+// unless the endpoint reports chainId. It asks for eth_chainId before the first one.
+func NewChainCheckEndpoint(endpoint Endpoint, chainId uint64) Endpoint {
+	return &chainCheckEndpoint{endpoint: endpoint, chainId: chainId}
+}
+
+func (e *chainCheckEndpoint) Url() string {
+	return e.endpoint.Url()
+}
+
+func (e *chainCheckEndpoint) Close() error {
+	if c, ok := e.endpoint.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (e *chainCheckEndpoint) Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error) {
+	if !isReadOnly(payload) {
+		if err := e.check(ui, send); err != nil {
+			return nil, err
+		}
+	}
+	return send(e.endpoint, payload)
+}
+
+// check remembers a mismatch, but asks again after a failed request
+func (e *chainCheckEndpoint) check(ui ui.Screen, send func(endpoint Endpoint, payload []byte) ([]byte, error)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ok || e.err != nil {
+		return e.err
+	}
+	payload, err := json.Marshal(RpcRequest{Id: nextIds(1), Version: "2.0", Method: "eth_chainId", Params: []interface{}{}})
+	if err != nil {
+		return err
+	}
+	body, err := send(e.endpoint, payload)
+	if err != nil {
+		return fmt.Errorf("failed to check the chain id of %s: %w", RedactUrl(e.endpoint.Url()), err)
+	}
+	resp := RpcResultStr{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Err != nil {
+		return fmt.Errorf("failed to check the chain id of %s: %w", RedactUrl(e.endpoint.Url()), withMethod(resp.Err, "eth_chainId"))
+	}
+	chainId, err := hexutil.DecodeUint64(resp.Result)
+	if err != nil {
+		return fmt.Errorf("invalid chain id of %s: %w", RedactUrl(e.endpoint.Url()), err)
+	}
+	if chainId != e.chainId {
+		e.err = fmt.Errorf("%w: %s is on chain %d, expected %d", ErrWrongChain, RedactUrl(e.endpoint.Url()), chainId, e.chainId)
+		return e.err
+	}
+	ui.Logf("%s is on chain %d\n", RedactUrl(e.endpoint.Url()), chainId)
+	e.ok = true
+	return nil
+}
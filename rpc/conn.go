@@ -0,0 +1,478 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaanek/jeth/ui"
+)
+
+var (
+	ErrConnClosed      = errors.New("connection closed")
+	ErrNotSubscriber   = errors.New("endpoint does not support subscriptions")
+	ErrUnsubscribed    = errors.New("unsubscribed")
+	ErrInvalidResponse = errors.New("invalid response")
+)
+
+// Subscription kinds accepted by eth_subscribe
+const (
+	NewHeads               = "newHeads"
+	Logs                   = "logs"
+	NewPendingTransactions = "newPendingTransactions"
+)
+
+// messageConn is a connection that carries whole json-rpc messages, like a websocket or
+// an ipc socket.
+type messageConn interface {
+	WriteMessage(msg []byte) error
+	ReadMessage() ([]byte, error)
+	Close() error
+}
+
+type pendingCall struct {
+	resp chan []byte
+	// set when the call is eth_subscribe, the subscription gets registered as soon as the
+	// response arrives so that no notification is lost
+	sub *Subscription
+	// abandoned is set when nobody waits for the eth_subscribe response anymore, the
+	// subscription it starts is ended right away
+	abandoned bool
+}
+
+// connEndpoint multiplexes requests and subscriptions over a single message connection.
+// The connection is dialed lazily and redialed after it breaks.
+type connEndpoint struct {
+	url  string
+	dial func() (messageConn, error)
+	// timeout limits each request when set, subscriptions live on after their request
+	timeout time.Duration
+
+	mu      sync.Mutex
+	conn    messageConn
+	closed  chan struct{}
+	connErr error
+	pending map[uint]*pendingCall
+	subs    map[string]*Subscription
+}
+
+func newConnEndpoint(url string, timeout time.Duration, dial func() (messageConn, error)) *connEndpoint {
+	return &connEndpoint{
+		url:     url,
+		dial:    dial,
+		timeout: timeout,
+	}
+}
+
+func (e *connEndpoint) Url() string {
+	return e.url
+}
+
+func (e *connEndpoint) RoundTrip(ctx context.Context, payload []byte) ([]byte, error) {
+	return e.send(ctx, payload, nil)
+}
+
+func (e *connEndpoint) send(ctx context.Context, payload []byte, sub *Subscription) ([]byte, error) {
+	ids, err := messageIds(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: request without id", ErrInvalidResponse)
+	}
+	call := &pendingCall{resp: make(chan []byte, 1), sub: sub}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	e.mu.Lock()
+	conn, closed, err := e.connect()
+	if err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	for _, id := range ids {
+		e.pending[id] = call
+	}
+	e.mu.Unlock()
+
+	if err := conn.WriteMessage(payload); err != nil {
+		e.fail(conn, err)
+		return nil, err
+	}
+	select {
+	case resp := <-call.resp:
+		return resp, nil
+	case <-closed:
+		return nil, e.closeErr()
+	case <-ctx.Done():
+		e.forget(ids, call)
+		return nil, ctx.Err()
+	}
+}
+
+// forget drops pending calls that nobody waits for anymore. A subscription can not be
+// dropped, the node would keep it, so it is unsubscribed as soon as it is acknowledged.
+func (e *connEndpoint) forget(ids []uint, call *pendingCall) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if call.sub == nil {
+		for _, id := range ids {
+			delete(e.pending, id)
+		}
+		return
+	}
+	if e.pending != nil && e.pending[ids[0]] == call {
+		call.abandoned = true
+		return
+	}
+	// the response came in while giving up
+	if call.sub.Id != "" && e.subs != nil {
+		delete(e.subs, call.sub.Id)
+		go e.unsubscribeId(call.sub.ui, call.sub.Id)
+	}
+	call.sub.end(ErrUnsubscribed)
+}
+
+// connect returns the current connection or dials a new one, must be called with mu held
+func (e *connEndpoint) connect() (messageConn, chan struct{}, error) {
+	if e.conn != nil {
+		return e.conn, e.closed, nil
+	}
+	conn, err := e.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	e.conn = conn
+	e.closed = make(chan struct{})
+	e.connErr = nil
+	e.pending = make(map[uint]*pendingCall)
+	e.subs = make(map[string]*Subscription)
+	go e.read(conn)
+	return conn, e.closed, nil
+}
+
+func (e *connEndpoint) closeErr() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.connErr != nil {
+		return e.connErr
+	}
+	return ErrConnClosed
+}
+
+func (e *connEndpoint) read(conn messageConn) {
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			e.fail(conn, err)
+			return
+		}
+		e.dispatch(conn, bytes.TrimSpace(msg))
+	}
+}
+
+type notification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func (e *connEndpoint) dispatch(conn messageConn, msg []byte) {
+	var n notification
+	if len(msg) > 0 && msg[0] == '{' && json.Unmarshal(msg, &n) == nil && n.Method == "eth_subscription" {
+		e.mu.Lock()
+		sub := e.subs[n.Params.Subscription]
+		e.mu.Unlock()
+		if sub != nil {
+			sub.deliver(n.Params.Result)
+		}
+		return
+	}
+	ids, err := messageIds(msg)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	var call *pendingCall
+	if len(ids) > 0 {
+		call = e.pending[ids[0]]
+	} else if rpcErr := nullIdError(msg); rpcErr != nil {
+		// nodes answer parse errors and invalid requests with a null id, it can only be
+		// told which call failed when a single one is waiting
+		var many bool
+		call, many = e.onlyPendingCall()
+		if many {
+			e.mu.Unlock()
+			e.fail(conn, fmt.Errorf("%w: %v", ErrInvalidResponse, rpcErr))
+			return
+		}
+	}
+	if call == nil {
+		e.mu.Unlock()
+		return
+	}
+	for id, c := range e.pending {
+		if c == call {
+			delete(e.pending, id)
+		}
+	}
+	if call.sub != nil {
+		resp := RpcResultStr{}
+		if json.Unmarshal(msg, &resp) == nil && resp.Err == nil && resp.Result != "" {
+			call.sub.Id = resp.Result
+			if call.abandoned {
+				// the read loop cannot wait for the answer
+				go e.unsubscribeId(call.sub.ui, resp.Result)
+				call.sub.end(ErrUnsubscribed)
+			} else {
+				e.subs[resp.Result] = call.sub
+				go call.sub.forward()
+			}
+		}
+	}
+	e.mu.Unlock()
+	call.resp <- msg
+}
+
+// onlyPendingCall returns the call waiting for a response, many is set when there are
+// several. Must be called with mu held.
+func (e *connEndpoint) onlyPendingCall() (call *pendingCall, many bool) {
+	for _, c := range e.pending {
+		if call != nil && c != call {
+			return nil, true
+		}
+		call = c
+	}
+	return call, false
+}
+
+// nullIdError returns the error of a response without an id
+func nullIdError(msg []byte) *RpcError {
+	var resp struct {
+		Error *RpcError `json:"error"`
+	}
+	if len(msg) == 0 || msg[0] != '{' || json.Unmarshal(msg, &resp) != nil {
+		return nil
+	}
+	return resp.Error
+}
+
+// fail closes the connection and ends all calls and subscriptions waiting on it
+func (e *connEndpoint) fail(conn messageConn, err error) {
+	e.mu.Lock()
+	if e.conn != conn {
+		e.mu.Unlock()
+		return
+	}
+	e.conn = nil
+	e.connErr = err
+	subs := e.subs
+	e.subs = nil
+	e.pending = nil
+	close(e.closed)
+	e.mu.Unlock()
+
+	conn.Close()
+	for _, sub := range subs {
+		sub.end(err)
+	}
+}
+
+func (e *connEndpoint) Close() error {
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	e.fail(conn, ErrConnClosed)
+	return nil
+}
+
+func (e *connEndpoint) Subscribe(ctx context.Context, ui ui.Screen, ch chan<- json.RawMessage, params []interface{}) (*Subscription, error) {
+	sub := &Subscription{
+		ui:       ui,
+		endpoint: e,
+		ch:       ch,
+		queue:    make(chan json.RawMessage, SubscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	payload, err := json.Marshal(&RpcRequest{
+		Id:      nextIds(1),
+		Version: "2.0",
+		Method:  "eth_subscribe",
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ui.Log(string(payload))
+	body, err := e.send(ctx, payload, sub)
+	if err != nil {
+		return nil, err
+	}
+	ui.Log(string(body))
+	resp := RpcResultStr{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	return sub, nil
+}
+
+func (e *connEndpoint) unsubscribe(sub *Subscription) error {
+	e.mu.Lock()
+	if e.subs != nil {
+		delete(e.subs, sub.Id)
+	}
+	e.mu.Unlock()
+	return e.unsubscribeId(sub.ui, sub.Id)
+}
+
+func (e *connEndpoint) unsubscribeId(ui ui.Screen, id string) error {
+	resp := RpcResultRaw{}
+	return Call(ui, nil, e, "eth_unsubscribe", []interface{}{id}, &resp)
+}
+
+// Subscriber is implemented by endpoints that support eth_subscribe
+type Subscriber interface {
+	Subscribe(ctx context.Context, ui ui.Screen, ch chan<- json.RawMessage, params []interface{}) (*Subscription, error)
+}
+
+// Subscribe starts an eth_subscribe subscription, params start with the subscription kind
+// (NewHeads, Logs, NewPendingTransactions). Notification results are delivered to ch.
+// The ctx only bounds the subscribe request, the subscription lives until Unsubscribe.
+func Subscribe(ctx context.Context, ui ui.Screen, endpoint Endpoint, ch chan<- json.RawMessage, params []interface{}) (*Subscription, error) {
+	s, ok := endpoint.(Subscriber)
+	if !ok {
+		return nil, ErrNotSubscriber
+	}
+	return s.Subscribe(ctx, ui, ch, params)
+}
+
+func IsSubscriber(endpoint Endpoint) bool {
+	_, ok := endpoint.(Subscriber)
+	return ok
+}
+
+// SubscriptionBuffer is how many notifications a subscription holds for a slow receiver,
+// more are dropped
+var SubscriptionBuffer = 1024
+
+// Subscription is an active eth_subscribe subscription. Notifications are buffered for the
+// receiving channel, when the receiver falls SubscriptionBuffer behind the newest ones are
+// dropped. Other calls on the connection are never held up.
+type Subscription struct {
+	Id       string
+	ui       ui.Screen
+	endpoint *connEndpoint
+	ch       chan<- json.RawMessage
+	queue    chan json.RawMessage
+	dropped  uint64
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// deliver is called by the read loop of the connection and must not block
+func (s *Subscription) deliver(msg json.RawMessage) {
+	select {
+	case s.queue <- msg:
+	default:
+		if n := atomic.AddUint64(&s.dropped, 1); n == 1 || n%1000 == 0 {
+			s.ui.Errorf("subscription %s: receiver is too slow, dropped %d notifications\n", s.Id, n)
+		}
+	}
+}
+
+// forward passes queued notifications to the receiving channel until the subscription ends
+func (s *Subscription) forward() {
+	for {
+		select {
+		case msg := <-s.queue:
+			select {
+			case s.ch <- msg:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Dropped returns how many notifications were dropped because the receiver was too slow
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Done is closed when the subscription ends
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription ended
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *Subscription) Unsubscribe() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.end(ErrUnsubscribed)
+	return s.endpoint.unsubscribe(s)
+}
+
+// messageIds returns the ids of a single or batch json-rpc message
+func messageIds(msg []byte) ([]uint, error) {
+	type head struct {
+		Id *uint `json:"id"`
+	}
+	var heads []head
+	if len(msg) > 0 && msg[0] == '[' {
+		if err := json.Unmarshal(msg, &heads); err != nil {
+			return nil, err
+		}
+	} else {
+		var h head
+		if err := json.Unmarshal(msg, &h); err != nil {
+			return nil, err
+		}
+		heads = append(heads, h)
+	}
+	ids := make([]uint, 0, len(heads))
+	for _, h := range heads {
+		if h.Id != nil {
+			ids = append(ids, *h.Id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,344 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+var ErrNoQuorum = errors.New("no quorum")
+
+// ConsensusAnswer is what one endpoint answered to a request
+type ConsensusAnswer struct {
+	Url    string
+	Result json.RawMessage
+	Err    error
+}
+
+// ConsensusError is returned when fewer than Quorum endpoints gave the same answer
+type ConsensusError struct {
+	Method  string
+	Block   string
+	Quorum  int
+	Answers []ConsensusAnswer
+}
+
+func (e *ConsensusError) Error() string {
+	// compare everyone with the most common answer
+	counts := map[string]int{}
+	var common string
+	for _, a := range e.Answers {
+		if a.Err == nil {
+			key := string(a.Result)
+			counts[key]++
+			if counts[key] > counts[common] {
+				common = key
+			}
+		}
+	}
+	var parts []string
+	for _, a := range e.Answers {
+		switch {
+		case a.Err != nil:
+			parts = append(parts, fmt.Sprintf("%s failed: %v", RedactUrl(a.Url), a.Err))
+		case string(a.Result) == common:
+			parts = append(parts, fmt.Sprintf("%s: %s", RedactUrl(a.Url), shorten(common)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s disagrees: %s", RedactUrl(a.Url), describeDiff(json.RawMessage(common), a.Result)))
+		}
+	}
+	at := ""
+	if e.Block != "" {
+		at = " at block " + e.Block
+	}
+	return fmt.Sprintf("%s: %v of %d%s: %s", e.Method, ErrNoQuorum, e.Quorum, at, strings.Join(parts, "; "))
+}
+
+func (e *ConsensusError) Unwrap() error {
+	return ErrNoQuorum
+}
+
+type consensusEndpoint struct {
+	endpoints []Endpoint
+	quorum    int
+}
+
+// NewConsensusEndpoint sends every request to all endpoints and returns an answer only when
+// at least quorum of them agree on it. Queries for the latest block are pinned to the
+// lowest head among the endpoints, so they all answer for the same block. A quorum of zero
+// means a majority. Requests that are not read only, like eth_sendRawTransaction, are sent
+// to the first endpoint alone, every node would sign or broadcast them otherwise. So are
+// filter and subscription calls, each node answers them with ids of its own.
+func NewConsensusEndpoint(endpoints []Endpoint, quorum int) Endpoint {
+	if quorum <= 0 {
+		quorum = len(endpoints)/2 + 1
+	}
+	return &consensusEndpoint{endpoints: endpoints, quorum: quorum}
+}
+
+func (e *consensusEndpoint) Url() string {
+	var urls []string
+	for _, endpoint := range e.endpoints {
+		urls = append(urls, endpoint.Url())
+	}
+	return strings.Join(urls, ",")
+}
+
+func (e *consensusEndpoint) Close() error {
+	for _, endpoint := range e.endpoints {
+		if c, ok := endpoint.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+func (e *consensusEndpoint) Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error) {
+	if !canSpread(payload) {
+		ui.Logf("no consensus on writes and filters, sending to %s alone\n", RedactUrl(e.endpoints[0].Url()))
+		return send(e.endpoints[0], payload)
+	}
+	if len(e.endpoints) < e.quorum {
+		return nil, fmt.Errorf("%w: quorum of %d needs as many endpoints, got %d", ErrNoQuorum, e.quorum, len(e.endpoints))
+	}
+	var reqs []rawRequest
+	if err := unmarshalMessages(payload, &reqs); err != nil {
+		return nil, err
+	}
+	batch := len(payload) > 0 && payload[0] == '['
+
+	block, err := e.pin(ctx, ui, send, reqs)
+	if err != nil {
+		return nil, err
+	}
+	if block != "" {
+		ui.Logf("consensus at block %s\n", block)
+		if batch {
+			payload, err = json.Marshal(reqs)
+		} else {
+			payload, err = json.Marshal(reqs[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// answers[i][j] is what endpoint i answered to request j
+	answers := make([]map[string]rawResponse, len(e.endpoints))
+	errs := make([]error, len(e.endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range e.endpoints {
+		wg.Add(1)
+		go func(i int, endpoint Endpoint) {
+			defer wg.Done()
+			body, err := send(endpoint, payload)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			var resps []rawResponse
+			if err := unmarshalMessages(body, &resps); err != nil {
+				errs[i] = err
+				return
+			}
+			answers[i] = map[string]rawResponse{}
+			for _, resp := range resps {
+				answers[i][string(resp.Id)] = resp
+			}
+		}(i, endpoint)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	agreed := make([]rawResponse, 0, len(reqs))
+	for _, req := range reqs {
+		resp, err := e.agree(req, block, answers, errs)
+		if err != nil {
+			return nil, err
+		}
+		agreed = append(agreed, resp)
+	}
+	if batch {
+		return json.Marshal(agreed)
+	}
+	return json.Marshal(agreed[0])
+}
+
+// agree returns the answer to req given by at least quorum endpoints
+func (e *consensusEndpoint) agree(req rawRequest, block string, answers []map[string]rawResponse, errs []error) (rawResponse, error) {
+	cerr := &ConsensusError{Method: req.Method, Block: block, Quorum: e.quorum}
+	votes := map[string]int{}
+	for i, endpoint := range e.endpoints {
+		answer := ConsensusAnswer{Url: endpoint.Url(), Err: errs[i]}
+		if answer.Err == nil {
+			resp, ok := answers[i][string(req.Id)]
+			switch {
+			case !ok:
+				answer.Err = ErrNoBatchResponse
+			case resp.Error != nil:
+				// an error is an answer too, clients word them differently so only the code and
+				// data have to match
+				answer.Result, _ = json.Marshal(map[string]interface{}{"error": map[string]interface{}{"code": resp.Error.Code, "data": resp.Error.Data}})
+			default:
+				canonical, err := canonicalParams(resp.Result)
+				if err != nil {
+					answer.Err = err
+				} else {
+					answer.Result = canonical
+				}
+			}
+		}
+		cerr.Answers = append(cerr.Answers, answer)
+		if answer.Result != nil {
+			votes[string(answer.Result)]++
+		}
+	}
+	for i, answer := range cerr.Answers {
+		if answer.Result == nil || votes[string(answer.Result)] < e.quorum {
+			continue
+		}
+		resp := answers[i][string(req.Id)]
+		resp.Version = "2.0"
+		return resp, nil
+	}
+	return rawResponse{}, cerr
+}
+
+// pin replaces missing and latest block params with the lowest head of the endpoints and
+// returns it, or an empty string when no request needed it
+func (e *consensusEndpoint) pin(ctx context.Context, ui ui.Screen, send func(endpoint Endpoint, payload []byte) ([]byte, error), reqs []rawRequest) (string, error) {
+	var pinned []int
+	for j, req := range reqs {
+		if i, ok := blockParamIndex[req.Method]; ok && isLatest(req.Params, i) {
+			pinned = append(pinned, j)
+		}
+	}
+	if len(pinned) == 0 {
+		return "", nil
+	}
+	payload, err := json.Marshal(RpcRequest{Id: nextIds(1), Version: "2.0", Method: "eth_blockNumber", Params: []interface{}{}})
+	if err != nil {
+		return "", err
+	}
+	heads := make([]uint64, 0, len(e.endpoints))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, endpoint := range e.endpoints {
+		wg.Add(1)
+		go func(endpoint Endpoint) {
+			defer wg.Done()
+			body, err := send(endpoint, payload)
+			if err != nil {
+				ui.Logf("failed to get head of %s: %v\n", RedactUrl(endpoint.Url()), err)
+				return
+			}
+			resp := RpcResultStr{}
+			if err := json.Unmarshal(body, &resp); err != nil || resp.Err != nil {
+				return
+			}
+			if head, err := hexutil.DecodeUint64(resp.Result); err == nil {
+				mu.Lock()
+				heads = append(heads, head)
+				mu.Unlock()
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if len(heads) < e.quorum {
+		return "", fmt.Errorf("%w: only %d of %d endpoints returned their head", ErrNoQuorum, len(heads), len(e.endpoints))
+	}
+	sort.Slice(heads, func(a, b int) bool { return heads[a] < heads[b] })
+	block := hexutil.EncodeUint64(heads[0])
+	for _, j := range pinned {
+		var args []json.RawMessage
+		if len(reqs[j].Params) > 0 && string(reqs[j].Params) != "null" {
+			if err := json.Unmarshal(reqs[j].Params, &args); err != nil {
+				return "", err
+			}
+		}
+		i := blockParamIndex[reqs[j].Method]
+		param, _ := json.Marshal(block)
+		if i < len(args) {
+			args[i] = param
+		} else if i == len(args) {
+			args = append(args, param)
+		} else {
+			continue
+		}
+		if reqs[j].Params, err = json.Marshal(args); err != nil {
+			return "", err
+		}
+	}
+	return block, nil
+}
+
+// isLatest reports whether the block param at index i is missing or latest. Pending is
+// passed on as it is, the pending state of a node is not in any block it could be pinned to.
+func isLatest(params json.RawMessage, i int) bool {
+	var args []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return false
+		}
+	}
+	if i >= len(args) {
+		return true
+	}
+	var tag string
+	if err := json.Unmarshal(args[i], &tag); err != nil {
+		return false
+	}
+	return tag == "latest"
+}
+
+// describeDiff tells how an answer differs from the reference, field by field for objects
+func describeDiff(reference, other json.RawMessage) string {
+	var refObj, otherObj map[string]json.RawMessage
+	if json.Unmarshal(reference, &refObj) != nil || json.Unmarshal(other, &otherObj) != nil {
+		return shorten(string(other))
+	}
+	var keys []string
+	for key := range refObj {
+		keys = append(keys, key)
+	}
+	for key := range otherObj {
+		if _, ok := refObj[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var diffs []string
+	for _, key := range keys {
+		a, b := refObj[key], otherObj[key]
+		if string(a) != string(b) {
+			if a == nil {
+				a = json.RawMessage("missing")
+			}
+			if b == nil {
+				b = json.RawMessage("missing")
+			}
+			diffs = append(diffs, fmt.Sprintf("%s %s instead of %s", key, shorten(string(b)), shorten(string(a))))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+func shorten(s string) string {
+	if len(s) > 80 {
+		return s[:77] + "..."
+	}
+	return s
+}
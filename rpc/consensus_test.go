@@ -0,0 +1,168 @@
+package rpc_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+// startConsensus starts a fake node per chain id and a consensus endpoint over them
+func startConsensus(t *testing.T, quorum int, chainIds ...uint64) ([]*rpctest.Server, rpc.Endpoint) {
+	var servers []*rpctest.Server
+	var endpoints []rpc.Endpoint
+	for _, id := range chainIds {
+		server := rpctest.NewServer()
+		t.Cleanup(server.Close)
+		server.SetChainId(id)
+		servers = append(servers, server)
+		endpoints = append(endpoints, server.Endpoint())
+	}
+	return servers, rpc.NewConsensusEndpoint(endpoints, quorum)
+}
+
+func TestConsensusDisagreement(t *testing.T) {
+	tests := []struct {
+		quorum int
+		want   string
+		err    string
+	}{
+		{quorum: 2, want: "0x1"},
+		// zero is a majority
+		{quorum: 0, want: "0x1"},
+		{quorum: 3, err: "eth_chainId: no quorum of 3: "},
+	}
+	for _, test := range tests {
+		_, endpoint := startConsensus(t, test.quorum, 1, 1, 2)
+		term := rpctest.NewScreen("")
+		var chainId string
+		err := rpc.CallResult(term, httpclient.NewDefault(term), endpoint, "eth_chainId", nil, &chainId)
+		if test.err != "" {
+			var cerr *rpc.ConsensusError
+			if !errors.As(err, &cerr) || !errors.Is(err, rpc.ErrNoQuorum) {
+				t.Fatalf("quorum %d: got %v, want a consensus error", test.quorum, err)
+			}
+			if msg := err.Error(); !strings.Contains(msg, test.err) || !strings.Contains(msg, `disagrees: "0x2"`) {
+				t.Errorf("quorum %d: got %q", test.quorum, msg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("quorum %d: %v", test.quorum, err)
+		}
+		if chainId != test.want {
+			t.Errorf("quorum %d: got %s, want %s", test.quorum, chainId, test.want)
+		}
+	}
+}
+
+func TestConsensusFailedEndpointsDoNotVote(t *testing.T) {
+	servers, endpoint := startConsensus(t, 2, 1, 1)
+	servers[1].FailNextHttp(http.StatusInternalServerError)
+	term := rpctest.NewScreen("")
+	// no retries, the failure is not hidden
+	client := httpclient.New(term, 1)
+
+	var chainId string
+	err := rpc.CallResult(term, client, endpoint, "eth_chainId", nil, &chainId)
+	if !errors.Is(err, rpc.ErrNoQuorum) || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("got %v, want no quorum with a failed endpoint", err)
+	}
+	if err := rpc.CallResult(term, client, endpoint, "eth_chainId", nil, &chainId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsensusErrorsVoteByCodeAndData(t *testing.T) {
+	to := common.HexToAddress("0x02")
+	call := []interface{}{map[string]interface{}{"to": to, "data": "0x"}, "0x1"}
+	revert := rpctest.RevertError("not allowed")
+
+	servers, endpoint := startConsensus(t, 2, 1, 1)
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+	servers[0].FailNext("eth_call", revert)
+	// worded differently by another client
+	servers[1].FailNext("eth_call", &rpc.RpcError{Code: revert.Code, Message: "VM Exception: revert", Data: revert.Data})
+	var result string
+	err := rpc.CallResult(term, client, endpoint, "eth_call", call, &result)
+	var rpcErr *rpc.RpcError
+	if !errors.As(err, &rpcErr) || !errors.Is(err, rpc.ErrExecutionReverted) {
+		t.Fatalf("got %v, want the agreed revert", err)
+	}
+
+	servers[0].FailNext("eth_call", revert)
+	servers[1].FailNext("eth_call", rpctest.RevertError("paused"))
+	if err := rpc.CallResult(term, client, endpoint, "eth_call", call, &result); !errors.Is(err, rpc.ErrNoQuorum) {
+		t.Fatalf("got %v, want no quorum for different revert data", err)
+	}
+}
+
+func TestConsensusSendsWritesAndFiltersToOneEndpoint(t *testing.T) {
+	servers, endpoint := startConsensus(t, 0, 1, 1, 1)
+	term := rpctest.NewScreen("")
+	var hash string
+	if err := rpc.CallResult(term, httpclient.NewDefault(term), endpoint, "eth_sendRawTransaction", []interface{}{"0x01"}, &hash); err != nil {
+		t.Fatal(err)
+	}
+	// each node would answer with a filter id of its own
+	var filter string
+	rpc.CallResult(term, httpclient.NewDefault(term), endpoint, "eth_newBlockFilter", nil, &filter)
+	for i, server := range servers {
+		want := 0
+		if i == 0 {
+			want = 1
+		}
+		if got := len(server.SentTransactions()); got != want {
+			t.Errorf("endpoint %d got %d transactions, want %d", i, got, want)
+		}
+		if got := len(server.Requests()); got != 2*want {
+			t.Errorf("endpoint %d got requests %v", i, server.Requests())
+		}
+	}
+}
+
+func TestConsensusPinsLatestButNotPending(t *testing.T) {
+	addr := common.HexToAddress("0x01")
+	var endpoints []rpc.Endpoint
+	for _, head := range []uint64{10, 12} {
+		server := rpctest.NewServer()
+		defer server.Close()
+		server.SetBlockNumber(head)
+		server.SetNonce(addr, 3, 5)
+		endpoints = append(endpoints, server.Endpoint())
+	}
+	endpoint := rpc.NewConsensusEndpoint(endpoints, 2)
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+
+	tests := []struct {
+		tag  string
+		want uint64
+	}{
+		// the fake server answers every block number with the latest nonce
+		{"latest", 3},
+		{"pending", 5},
+	}
+	for _, test := range tests {
+		var nonce hexutil.Uint64
+		if err := rpc.CallResult(term, client, endpoint, "eth_getTransactionCount", []interface{}{addr, test.tag}, &nonce); err != nil {
+			t.Fatalf("%s: %v", test.tag, err)
+		}
+		if uint64(nonce) != test.want {
+			t.Errorf("%s: nonce %d, want %d", test.tag, nonce, test.want)
+		}
+	}
+	for _, log := range term.Logs() {
+		if log == "consensus at block 0xa\n" {
+			return
+		}
+	}
+	t.Errorf("latest was not pinned to the lowest head, logs: %v", term.Logs())
+}
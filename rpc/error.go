@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+// Well known error classes, test for them with errors.Is
+var (
+	ErrExecutionReverted = errors.New("execution reverted")
+	ErrNonceTooLow       = errors.New("nonce too low")
+	ErrUnderpriced       = errors.New("transaction underpriced")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrMethodNotFound    = errors.New("method not found")
+	// ErrTransient errors are likely to go away when the call is repeated, e.g. a rate limit
+	// or a load balanced node that is behind the others
+	ErrTransient = errors.New("transient error")
+)
+
+// https://www.jsonrpc.org/specification#error_object
+const (
+	CodeMethodNotFound     = -32601
+	CodeLimitExceeded      = -32005
+	CodeExecutionReverted  = 3
+	maxHttpErrorBodyLength = 256
+)
+
+// RpcError is a json-rpc error object extended with the http status and method name of
+// the failed request
+type RpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+
+	// HttpStatus is set when the endpoint replied with a non 2xx status
+	HttpStatus int    `json:"-"`
+	Method     string `json:"-"`
+}
+
+func (e *RpcError) Error() string {
+	msg := fmt.Sprintf("code: %d, message: %s", e.Code, e.Message)
+	if e.hasData() {
+		msg = fmt.Sprintf("%s, data: %s", msg, e.Data)
+	}
+	if e.HttpStatus != 0 {
+		msg = fmt.Sprintf("http status: %d, %s", e.HttpStatus, msg)
+	}
+	if e.Method != "" {
+		msg = fmt.Sprintf("%s: %s", e.Method, msg)
+	}
+	return msg
+}
+
+func (e *RpcError) Is(target error) bool {
+	msg := strings.ToLower(e.Message)
+	switch target {
+	case ErrExecutionReverted:
+		return e.Code == CodeExecutionReverted || strings.Contains(msg, "execution reverted")
+	case ErrNonceTooLow:
+		return strings.Contains(msg, "nonce too low")
+	case ErrUnderpriced:
+		return strings.Contains(msg, "underpriced")
+	case ErrRateLimited:
+		return e.HttpStatus == http.StatusTooManyRequests || e.Code == CodeLimitExceeded ||
+			strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests") || strings.Contains(msg, "limit exceeded")
+	case ErrMethodNotFound:
+		return e.Code == CodeMethodNotFound || strings.Contains(msg, "does not exist") || strings.Contains(msg, "method not found")
+	case ErrTransient:
+		if e.Is(ErrRateLimited) {
+			return true
+		}
+		for _, transient := range transientMessages {
+			if strings.Contains(msg, transient) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// messages of errors that go away by themselves, the codes differ between nodes
+var transientMessages = []string{
+	"header not found",
+	"unknown block",
+	"timed out",
+	"timeout",
+	"try again",
+	"temporarily unavailable",
+	"busy",
+}
+
+func (e *RpcError) hasData() bool {
+	return len(e.Data) > 0 && string(e.Data) != "null"
+}
+
+// RevertData returns the data field decoded from hex, nodes put the revert payload there
+func (e *RpcError) RevertData() ([]byte, error) {
+	if !e.hasData() {
+		return nil, nil
+	}
+	var str string
+	if err := json.Unmarshal(e.Data, &str); err != nil {
+		return nil, err
+	}
+	return hexutil.Decode(str)
+}
+
+// revert reason is abi encoded as Error(string)
+var revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// RevertReason returns the message passed to revert() or require() if the data holds one
+func (e *RpcError) RevertReason() (string, bool) {
+	data, err := e.RevertData()
+	if err != nil || len(data) < 4+64 || string(data[:4]) != string(revertSelector) {
+		return "", false
+	}
+	data = data[4:]
+	offset := binary.BigEndian.Uint64(data[24:32])
+	if offset+32 > uint64(len(data)) {
+		return "", false
+	}
+	length := binary.BigEndian.Uint64(data[offset+24 : offset+32])
+	start := offset + 32
+	if start+length > uint64(len(data)) {
+		return "", false
+	}
+	return string(data[start : start+length]), true
+}
+
+// newHttpError returns the json-rpc error in body when there is one, otherwise an error
+// holding the start of the body. Html error pages from proxies end up here.
+func newHttpError(status int, body []byte) *RpcError {
+	resp := RpcResultRaw{}
+	if json.Unmarshal(body, &resp) == nil && resp.Err != nil {
+		resp.Err.HttpStatus = status
+		return resp.Err
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxHttpErrorBodyLength {
+		text = text[:maxHttpErrorBodyLength] + "..."
+	}
+	msg := http.StatusText(status)
+	if text != "" {
+		msg = fmt.Sprintf("%s: %s", msg, text)
+	}
+	return &RpcError{
+		HttpStatus: status,
+		Message:    msg,
+	}
+}
+
+// withMethod records the method name on an rpc error
+func withMethod(err error, method string) error {
+	var e *RpcError
+	if errors.As(err, &e) && e.Method == "" {
+		e.Method = method
+	}
+	return err
+}
+
+// errorCode labels an error for metrics, empty for no error
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var e *RpcError
+	if errors.As(err, &e) {
+		if e.Code == 0 && e.HttpStatus != 0 {
+			return fmt.Sprintf("http_%d", e.HttpStatus)
+		}
+		return strconv.Itoa(e.Code)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "canceled"
+	}
+	return "transport"
+}
@@ -0,0 +1,293 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/metrics"
+	"github.com/jaanek/jeth/ui"
+)
+
+// UnhealthyDuration is how long a failed endpoint is skipped before it is tried again
+var UnhealthyDuration = 30 * time.Second
+
+// readMethods do not change state, sign or manage the node. Only these are passed by a
+// read only proxy and, unless they are sticky, spread round robin. Any other method counts
+// as a write.
+var readMethods = map[string]bool{
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+	"net_version":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"eth_protocolVersion":                     true,
+	"eth_syncing":                             true,
+	"eth_chainId":                             true,
+	"eth_mining":                              true,
+	"eth_hashrate":                            true,
+	"eth_gasPrice":                            true,
+	"eth_maxPriorityFeePerGas":                true,
+	"eth_feeHistory":                          true,
+	"eth_blockNumber":                         true,
+	"eth_getBalance":                          true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionCount":                 true,
+	"eth_getCode":                             true,
+	"eth_getProof":                            true,
+	"eth_call":                                true,
+	"eth_estimateGas":                         true,
+	"eth_createAccessList":                    true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockReceipts":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getUncleByBlockHashAndIndex":         true,
+	"eth_getUncleByBlockNumberAndIndex":       true,
+	"eth_getUncleCountByBlockHash":            true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getLogs":                             true,
+	"eth_newFilter":                           true,
+	"eth_newBlockFilter":                      true,
+	"eth_newPendingTransactionFilter":         true,
+	"eth_getFilterChanges":                    true,
+	"eth_getFilterLogs":                       true,
+	"eth_uninstallFilter":                     true,
+	"eth_subscribe":                           true,
+	"eth_unsubscribe":                         true,
+	"txpool_content":                          true,
+	"txpool_inspect":                          true,
+	"txpool_status":                           true,
+	"debug_traceTransaction":                  true,
+	"debug_traceCall":                         true,
+	"debug_traceBlockByHash":                  true,
+	"debug_traceBlockByNumber":                true,
+	"trace_block":                             true,
+	"trace_call":                              true,
+	"trace_filter":                            true,
+	"trace_get":                               true,
+	"trace_transaction":                       true,
+	"trace_replayTransaction":                 true,
+	"trace_replayBlockTransactions":           true,
+}
+
+// stickyMethods create or use state that only exists on the node that answered them, like
+// filter and subscription ids. They are read only but never spread round robin.
+var stickyMethods = map[string]bool{
+	"eth_newFilter":                   true,
+	"eth_newBlockFilter":              true,
+	"eth_newPendingTransactionFilter": true,
+	"eth_getFilterChanges":            true,
+	"eth_getFilterLogs":               true,
+	"eth_uninstallFilter":             true,
+	"eth_subscribe":                   true,
+	"eth_unsubscribe":                 true,
+}
+
+func init() {
+	for method := range readMethods {
+		metrics.AddMethods(method)
+	}
+	metrics.AddMethods("eth_sendRawTransaction", "eth_sendTransaction")
+}
+
+type failoverEndpoint struct {
+	urls       []string
+	endpoints  []Endpoint
+	roundRobin bool
+	clock      httpclient.Clock
+
+	mu        sync.Mutex
+	downUntil []time.Time
+	next      int
+}
+
+// NewFailoverEndpoint returns an endpoint that sends each request to the first healthy url
+// in order and fails over to the next one on transport errors, 5xx and rate limit
+// responses. A failed url is skipped for UnhealthyDuration. With roundRobin read requests
+// are spread over all healthy urls, except for filter and subscription calls.
+func NewFailoverEndpoint(urls []string, roundRobin bool, opts ...EndpointOption) Endpoint {
+	var endpoints []Endpoint
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		endpoints = append(endpoints, NewEndpoint(url, opts...))
+	}
+	return NewFailover(endpoints, roundRobin)
+}
+
+// NewFailover is NewFailoverEndpoint over already configured endpoints
+func NewFailover(endpoints []Endpoint, roundRobin bool) Endpoint {
+	e := &failoverEndpoint{
+		endpoints:  endpoints,
+		roundRobin: roundRobin,
+		clock:      httpclient.DefaultClock,
+		downUntil:  make([]time.Time, len(endpoints)),
+	}
+	for _, endpoint := range endpoints {
+		e.urls = append(e.urls, endpoint.Url())
+	}
+	return e
+}
+
+func (e *failoverEndpoint) Url() string {
+	return strings.Join(e.urls, ",")
+}
+
+func (e *failoverEndpoint) Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error) {
+	if len(e.endpoints) == 0 {
+		return nil, errors.New("no endpoints to send the request to")
+	}
+	var lastErr error
+	for _, i := range e.order(canSpread(payload)) {
+		body, err := send(e.endpoints[i], payload)
+		if err == nil {
+			e.setHealthy(i)
+			return body, nil
+		}
+		// the caller gave up, which says nothing about the endpoint
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !isFailoverError(err) {
+			return nil, err
+		}
+		ui.Logf("endpoint %s failed, marking it unhealthy: %v\n", RedactUrl(e.urls[i]), err)
+		e.setUnhealthy(i)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// order returns the endpoint indexes to try, healthy ones first. Unhealthy endpoints are
+// still tried last, when everything else failed.
+func (e *failoverEndpoint) order(spread bool) []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(e.endpoints)
+	start := 0
+	if e.roundRobin && spread {
+		start = e.next % n
+		e.next++
+	}
+	now := e.clock.Now()
+	healthy := make([]int, 0, n)
+	unhealthy := make([]int, 0)
+	for j := 0; j < n; j++ {
+		i := (start + j) % n
+		if now.Before(e.downUntil[i]) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (e *failoverEndpoint) setHealthy(i int) {
+	e.mu.Lock()
+	e.downUntil[i] = time.Time{}
+	e.mu.Unlock()
+}
+
+func (e *failoverEndpoint) setUnhealthy(i int) {
+	e.mu.Lock()
+	e.downUntil[i] = e.clock.Now().Add(UnhealthyDuration)
+	e.mu.Unlock()
+}
+
+func (e *failoverEndpoint) Close() error {
+	for _, endpoint := range e.endpoints {
+		if c, ok := endpoint.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// isFailoverError reports whether another endpoint might succeed where this one failed
+func isFailoverError(err error) bool {
+	var e *RpcError
+	if !errors.As(err, &e) {
+		// transport error, the endpoint was not reached
+		return true
+	}
+	return e.HttpStatus >= http.StatusInternalServerError || errors.Is(e, ErrRateLimited)
+}
+
+// IsReadMethod reports whether method is known not to change state, sign or manage the node
+func IsReadMethod(method string) bool {
+	return readMethods[method]
+}
+
+// IsWriteMethod reports whether method might change state, it is any method that is not a
+// known read method
+func IsWriteMethod(method string) bool {
+	return !readMethods[method]
+}
+
+// isReadOnly reports whether none of the requests in a single or batch message changes state
+func isReadOnly(payload []byte) bool {
+	methods, err := payloadMethods(payload)
+	if err != nil {
+		return false
+	}
+	for _, method := range methods {
+		if !readMethods[method] {
+			return false
+		}
+	}
+	return true
+}
+
+// canSpread reports whether a single or batch message can go to any of the endpoints, it
+// is read only and uses no state of a particular node
+func canSpread(payload []byte) bool {
+	if !isReadOnly(payload) {
+		return false
+	}
+	methods, _ := payloadMethods(payload)
+	for _, method := range methods {
+		if stickyMethods[method] {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadMethods returns the methods of the requests in a single or batch message
+func payloadMethods(payload []byte) ([]string, error) {
+	type head struct {
+		Method string `json:"method"`
+	}
+	var heads []head
+	if len(payload) > 0 && payload[0] == '[' {
+		if err := json.Unmarshal(payload, &heads); err != nil {
+			return nil, err
+		}
+	} else {
+		var h head
+		if err := json.Unmarshal(payload, &h); err != nil {
+			return nil, err
+		}
+		heads = append(heads, h)
+	}
+	methods := make([]string, 0, len(heads))
+	for _, h := range heads {
+		methods = append(methods, h.Method)
+	}
+	return methods, nil
+}
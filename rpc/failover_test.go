@@ -0,0 +1,168 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// startFailover starts a fake node for each chain id, so the answer tells which node gave
+// it, and a failover endpoint over them that tells the time by clock
+func startFailover(t *testing.T, roundRobin bool, chainIds ...uint64) ([]*rpctest.Server, rpc.Endpoint, *rpctest.Clock) {
+	clock := rpctest.NewClock()
+	old := httpclient.DefaultClock
+	httpclient.DefaultClock = clock
+	defer func() { httpclient.DefaultClock = old }()
+
+	var servers []*rpctest.Server
+	var endpoints []rpc.Endpoint
+	for _, id := range chainIds {
+		server := rpctest.NewServer()
+		t.Cleanup(server.Close)
+		server.SetChainId(id)
+		servers = append(servers, server)
+		endpoints = append(endpoints, server.Endpoint())
+	}
+	return servers, rpc.NewFailover(endpoints, roundRobin), clock
+}
+
+// chainId asks endpoint without retries, so every failure reaches the failover
+func chainId(ctx context.Context, endpoint rpc.Endpoint) (string, error) {
+	term := rpctest.NewScreen("")
+	var result string
+	err := rpc.CallResultContext(ctx, term, httpclient.New(term, 1), endpoint, "eth_chainId", nil, &result)
+	return result, err
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     func(s *rpctest.Server)
+		want     string
+		rpcErr   bool
+		failover bool
+	}{
+		{name: "healthy", fail: func(s *rpctest.Server) {}, want: "0x1"},
+		{name: "5xx", fail: func(s *rpctest.Server) { s.FailNextHttp(http.StatusBadGateway) }, want: "0x2", failover: true},
+		{name: "rate limit", fail: func(s *rpctest.Server) { s.FailNextHttp(http.StatusTooManyRequests) }, want: "0x2", failover: true},
+		{
+			name: "rpc error",
+			fail: func(s *rpctest.Server) {
+				s.FailNext("eth_chainId", &rpc.RpcError{Code: -32000, Message: "something broke"})
+			},
+			rpcErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			servers, endpoint, _ := startFailover(t, false, 1, 2)
+			test.fail(servers[0])
+			got, err := chainId(context.Background(), endpoint)
+			if test.rpcErr {
+				var rpcErr *rpc.RpcError
+				if !errors.As(err, &rpcErr) || rpcErr.Message != "something broke" {
+					t.Fatalf("got %v, want the error of the first endpoint", err)
+				}
+				if len(servers[1].Requests()) != 0 {
+					t.Error("failed over on an rpc error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("answered by %s, want %s", got, test.want)
+			}
+			// a failed endpoint is skipped by the next request
+			got, _ = chainId(context.Background(), endpoint)
+			if skipped := got == "0x2"; skipped != test.failover {
+				t.Errorf("next request answered by %s, failed over %v", got, test.failover)
+			}
+		})
+	}
+}
+
+func TestFailoverSkipsUnhealthyEndpointForAWhile(t *testing.T) {
+	servers, endpoint, clock := startFailover(t, false, 1, 2)
+	servers[0].FailNextHttp(http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		if got, err := chainId(context.Background(), endpoint); err != nil || got != "0x2" {
+			t.Fatalf("request %d: got %s, %v, want 0x2", i, got, err)
+		}
+	}
+	clock.Advance(rpc.UnhealthyDuration - time.Second)
+	if got, _ := chainId(context.Background(), endpoint); got != "0x2" {
+		t.Errorf("got %s before the unhealthy time is over, want 0x2", got)
+	}
+	clock.Advance(time.Second)
+	if got, _ := chainId(context.Background(), endpoint); got != "0x1" {
+		t.Errorf("got %s after the unhealthy time, want the first endpoint again", got)
+	}
+}
+
+func TestFailoverTriesUnhealthyEndpointsLast(t *testing.T) {
+	servers, endpoint, _ := startFailover(t, false, 1, 2)
+	servers[0].FailNextHttp(http.StatusServiceUnavailable)
+	chainId(context.Background(), endpoint)
+	// the healthy endpoint fails too, the unhealthy one is the last resort
+	servers[1].FailNextHttp(http.StatusServiceUnavailable)
+	if got, err := chainId(context.Background(), endpoint); err != nil || got != "0x1" {
+		t.Errorf("got %s, %v, want the unhealthy endpoint to answer", got, err)
+	}
+}
+
+func TestFailoverCancelledContext(t *testing.T) {
+	servers, endpoint, _ := startFailover(t, false, 1, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := chainId(ctx, endpoint); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if len(servers[1].Requests()) != 0 {
+		t.Error("failed over when the caller gave up")
+	}
+	// and the first endpoint was not marked unhealthy
+	if got, _ := chainId(context.Background(), endpoint); got != "0x1" {
+		t.Errorf("got %s, want 0x1", got)
+	}
+}
+
+func TestFailoverRoundRobin(t *testing.T) {
+	servers, endpoint, _ := startFailover(t, true, 1, 2)
+	var got []string
+	for i := 0; i < 4; i++ {
+		id, err := chainId(context.Background(), endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if got[0] == got[1] || got[0] != got[2] || got[1] != got[3] {
+		t.Errorf("reads answered by %v, want them spread over both endpoints", got)
+	}
+
+	// filters and writes stay on the first endpoint
+	term := rpctest.NewScreen("")
+	client := httpclient.New(term, 1)
+	for i := 0; i < 4; i++ {
+		var result string
+		rpc.CallResult(term, client, endpoint, "eth_newBlockFilter", nil, &result)
+		rpc.CallResult(term, client, endpoint, "eth_getFilterChanges", []interface{}{"0x1"}, &result)
+		rpc.CallResult(term, client, endpoint, "eth_sendRawTransaction", []interface{}{"0x01"}, &result)
+	}
+	for _, method := range servers[1].Requests() {
+		if method != "eth_chainId" {
+			t.Errorf("%s was sent to the second endpoint", method)
+		}
+	}
+	if n := len(servers[0].SentTransactions()); n != 4 {
+		t.Errorf("first endpoint got %d transactions, want 4", n)
+	}
+}
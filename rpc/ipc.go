@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var IpcDialTimeout = 10 * time.Second
+
+// IsIpcPath reports whether url points to a unix domain socket, given either as ipc://path
+// or as a plain filesystem path
+func IsIpcPath(url string) bool {
+	if strings.HasPrefix(url, "ipc://") {
+		return true
+	}
+	if strings.Contains(url, "://") {
+		return false
+	}
+	return strings.HasSuffix(url, ".ipc") || strings.HasPrefix(url, "/") || strings.HasPrefix(url, ".")
+}
+
+// NewIpcEndpoint returns an endpoint that speaks newline delimited json-rpc over a unix
+// domain socket. It supports subscriptions. Of the options only WithTimeout applies.
+func NewIpcEndpoint(url string, opts ...EndpointOption) Endpoint {
+	path := strings.TrimPrefix(url, "ipc://")
+	o := newEndpointOptions(opts)
+	return newConnEndpoint(url, o.timeout, func() (messageConn, error) {
+		conn, err := net.DialTimeout("unix", path, IpcDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &ipcConn{conn: conn, dec: json.NewDecoder(conn)}, nil
+	})
+}
+
+type ipcConn struct {
+	conn net.Conn
+	dec  *json.Decoder
+	wmu  sync.Mutex
+}
+
+func (c *ipcConn) WriteMessage(msg []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(append(msg, '\n'))
+	return err
+}
+
+// ReadMessage decodes the next json value, nodes do not always delimit them by newlines
+func (c *ipcConn) ReadMessage() ([]byte, error) {
+	var msg json.RawMessage
+	if err := c.dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *ipcConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,146 @@
+package rpc_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// serveIpc listens on a unix socket in a temp dir and runs handle for every connection
+func serveIpc(t *testing.T, handle func(conn net.Conn)) string {
+	path := filepath.Join(t.TempDir(), "node.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return path
+}
+
+func TestIpcTimeout(t *testing.T) {
+	path := serveIpc(t, func(conn net.Conn) {
+		// reads the request and never answers
+		buf := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	})
+	endpoint := rpc.NewEndpoint(path, rpc.WithTimeout(50*time.Millisecond))
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	var result string
+	err := rpc.CallResultContext(context.Background(), term, nil, endpoint, "eth_chainId", nil, &result)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// answerIpc answers every request on conn with its first param, in the order given by
+// order, which gets the requests in the order they came in
+func answerIpc(conn net.Conn, order func(reqs []testRequest) []testRequest, batchSize int) {
+	defer conn.Close()
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	var reqs []testRequest
+	for {
+		var req testRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if reqs = append(reqs, req); len(reqs) < batchSize {
+			continue
+		}
+		for _, req := range order(reqs) {
+			msg, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": req.Params[0]})
+			// nodes do not always put newlines between messages
+			conn.Write(msg)
+		}
+		reqs = nil
+	}
+}
+
+func TestIpcConcurrentCalls(t *testing.T) {
+	const calls = 10
+	reverse := func(reqs []testRequest) []testRequest {
+		for i, j := 0, len(reqs)-1; i < j; i, j = i+1, j-1 {
+			reqs[i], reqs[j] = reqs[j], reqs[i]
+		}
+		return reqs
+	}
+	// the answers come only when all calls are in, in reverse order
+	path := serveIpc(t, func(conn net.Conn) { answerIpc(conn, reverse, calls) })
+	endpoint := rpc.NewEndpoint(path)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var result string
+			if err := rpc.CallResult(term, nil, endpoint, "test_echo", []interface{}{fmt.Sprint(i)}, &result); err != nil {
+				t.Error(err)
+				return
+			}
+			if result != fmt.Sprint(i) {
+				t.Errorf("call %d got the answer %s", i, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestIpcDroppedConnection(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	path := serveIpc(t, func(conn net.Conn) {
+		mu.Lock()
+		connections++
+		first := connections == 1
+		mu.Unlock()
+		if first {
+			// read the request and hang up without an answer
+			json.NewDecoder(conn).Decode(&testRequest{})
+			conn.Close()
+			return
+		}
+		answerIpc(conn, func(reqs []testRequest) []testRequest { return reqs }, 1)
+	})
+	endpoint := rpc.NewEndpoint(path)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	var result string
+	if err := rpc.CallResult(term, nil, endpoint, "test_echo", []interface{}{"a"}, &result); err == nil {
+		t.Fatal("expected the call to fail when the node hangs up")
+	}
+	// the next call dials again
+	if err := rpc.CallResult(term, nil, endpoint, "test_echo", []interface{}{"b"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "b" {
+		t.Errorf("got %s, want b", result)
+	}
+}
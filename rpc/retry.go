@@ -0,0 +1,202 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/metrics"
+	"github.com/jaanek/jeth/ui"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/crypto"
+)
+
+// RetryCheck decides whether a call answered with a json-rpc error is sent again. Calls
+// that change state are never passed to it, except eth_sendRawTransaction which is only
+// sent again when the node does not know the transaction.
+var RetryCheck = func(method string, err *RpcError) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// sendWithRetries sends payload to a single endpoint. Calls answered with a transient
+// json-rpc error are sent again following the retry policy of client. Only the failed
+// calls of a batch are sent again.
+func sendWithRetries(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, payload []byte) ([]byte, error) {
+	policy := retryPolicy(client)
+	body, err := send(ctx, ui, client, endpoint, payload)
+	if err != nil {
+		return resendTransaction(ctx, ui, client, endpoint, payload, err)
+	}
+	var reqs []rawRequest
+	var resps []rawResponse
+	if unmarshalMessages(payload, &reqs) != nil || unmarshalMessages(body, &resps) != nil {
+		return body, nil
+	}
+	answers := make(map[string]rawResponse, len(resps))
+	for _, resp := range resps {
+		answers[string(resp.Id)] = resp
+	}
+
+	modified := false
+	for attempt := 1; ; attempt++ {
+		var again []rawRequest
+		var lastErr *RpcError
+		for _, req := range reqs {
+			resp, ok := answers[string(req.Id)]
+			if len(req.Id) == 0 || !ok || resp.Error == nil || !retryable(req.Method, resp.Error) {
+				continue
+			}
+			if req.Method == "eth_sendRawTransaction" {
+				// it might have been accepted before the error, sending it again would fail
+				if hash, known := knownTransaction(ctx, ui, client, endpoint, req); known {
+					ui.Logf("%s: transaction %s was sent despite the error: %s\n", RedactUrl(endpoint.Url()), hash, resp.Error.Message)
+					answers[string(req.Id)] = rawResponse{Version: "2.0", Id: req.Id, Result: json.RawMessage(`"` + hash + `"`)}
+					modified = true
+					continue
+				}
+			}
+			again = append(again, req)
+			lastErr = resp.Error
+		}
+		if len(again) == 0 || attempt >= policy.RetryMax {
+			break
+		}
+		if !policy.RetryBudget.Take() {
+			ui.Logf("%s: retry budget used up\n", RedactUrl(endpoint.Url()))
+			break
+		}
+		delay := policy.RetryWaitDelay(attempt, nil)
+		ui.Logf("%s: %s, retrying %d calls in %s\n", RedactUrl(endpoint.Url()), lastErr.Message, len(again), delay)
+		for _, req := range again {
+			metrics.RpcRetry(req.Method)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-policy.Clock.After(delay):
+		}
+
+		var retryPayload []byte
+		if len(again) == 1 && !isBatch(payload) {
+			retryPayload, err = json.Marshal(again[0])
+		} else {
+			retryPayload, err = json.Marshal(again)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ui.Log(string(retryPayload))
+		retryBody, err := send(ctx, ui, client, endpoint, retryPayload)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// the answers we have are better than none
+			ui.Errorf("%s: retry failed: %v\n", RedactUrl(endpoint.Url()), err)
+			break
+		}
+		ui.Log(string(retryBody))
+		var retried []rawResponse
+		if unmarshalMessages(retryBody, &retried) != nil {
+			break
+		}
+		for _, resp := range retried {
+			if _, ok := answers[string(resp.Id)]; ok {
+				answers[string(resp.Id)] = resp
+				modified = true
+			}
+		}
+	}
+	if !modified {
+		return body, nil
+	}
+	merged := make([]rawResponse, 0, len(resps))
+	for _, resp := range resps {
+		merged = append(merged, answers[string(resp.Id)])
+	}
+	if isBatch(body) {
+		return json.Marshal(merged)
+	}
+	return json.Marshal(merged[0])
+}
+
+// resendTransaction handles a failed send of payload. The http client does not retry calls
+// that change state, the node might have processed them before the attempt failed. A single
+// eth_sendRawTransaction is sent again following the retry policy of client, but only
+// while the node does not know the transaction. Other payloads return err.
+func resendTransaction(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, payload []byte, err error) ([]byte, error) {
+	var req rawRequest
+	if isBatch(payload) || json.Unmarshal(payload, &req) != nil || req.Method != "eth_sendRawTransaction" {
+		return nil, err
+	}
+	policy := retryPolicy(client)
+	for attempt := 1; attempt < policy.RetryMax; attempt++ {
+		if ctx.Err() != nil || !isFailoverError(err) {
+			return nil, err
+		}
+		if hash, known := knownTransaction(ctx, ui, client, endpoint, req); known {
+			ui.Logf("%s: transaction %s was sent despite the error: %v\n", RedactUrl(endpoint.Url()), hash, err)
+			return json.Marshal(rawResponse{Version: "2.0", Id: req.Id, Result: json.RawMessage(`"` + hash + `"`)})
+		}
+		if !policy.RetryBudget.Take() {
+			ui.Logf("%s: retry budget used up\n", RedactUrl(endpoint.Url()))
+			return nil, err
+		}
+		delay := policy.RetryWaitDelay(attempt, nil)
+		ui.Logf("%s: %v, sending the transaction again in %s\n", RedactUrl(endpoint.Url()), err, delay)
+		metrics.RpcRetry(req.Method)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-policy.Clock.After(delay):
+		}
+		var body []byte
+		body, err = send(ctx, ui, client, endpoint, payload)
+		if err == nil {
+			return body, nil
+		}
+	}
+	return nil, err
+}
+
+// retryPolicy returns the policy of client, calls over websocket and ipc may be made
+// without a client and are not retried
+func retryPolicy(client httpclient.HttpClient) httpclient.RetryPolicy {
+	if client == nil {
+		return httpclient.RetryPolicy{RetryMax: 1}
+	}
+	return client.Policy()
+}
+
+func retryable(method string, err *RpcError) bool {
+	if IsWriteMethod(method) && method != "eth_sendRawTransaction" {
+		return false
+	}
+	return RetryCheck(method, err)
+}
+
+// knownTransaction returns the hash of the raw transaction sent by req and whether the
+// endpoint knows it
+func knownTransaction(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, req rawRequest) (string, bool) {
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return "", false
+	}
+	raw, err := hexutil.Decode(params[0])
+	if err != nil {
+		return "", false
+	}
+	hash := crypto.Keccak256Hash(raw).Hex()
+	resp := RpcResultRaw{}
+	if err := CallContext(ctx, ui, client, endpoint, "eth_getTransactionByHash", []interface{}{hash}, &resp); err != nil {
+		return hash, false
+	}
+	return hash, !resp.IsNull()
+}
+
+func isBatch(msg []byte) bool {
+	msg = bytes.TrimSpace(msg)
+	return len(msg) > 0 && msg[0] == '['
+}
@@ -0,0 +1,93 @@
+package rpc_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// noWaits makes the clients created until the returned func is called retry at once
+func noWaits() func() {
+	backoff := httpclient.DefaultBackoff
+	httpclient.DefaultBackoff = httpclient.Backoff{}
+	return func() { httpclient.DefaultBackoff = backoff }
+}
+
+func TestSendRawTransactionNotRetriedByHttp(t *testing.T) {
+	defer noWaits()()
+	server := rpctest.NewServer()
+	defer server.Close()
+	server.FailNextHttp(http.StatusBadGateway)
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+
+	var hash string
+	err := rpc.CallResultContext(context.Background(), term, client, server.Endpoint(), "eth_sendRawTransaction", []interface{}{"0x01"}, &hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the node is asked whether it got the transaction before it is sent again
+	want := []string{"eth_getTransactionByHash", "eth_sendRawTransaction"}
+	if got := server.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests %v, want %v", got, want)
+	}
+	if len(server.SentTransactions()) != 1 {
+		t.Errorf("sent %d transactions, want 1", len(server.SentTransactions()))
+	}
+}
+
+func TestSendRawTransactionKnownAfterFailure(t *testing.T) {
+	defer noWaits()()
+	server := rpctest.NewServer()
+	defer server.Close()
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+	var first string
+	if err := rpc.CallResult(term, client, server.Endpoint(), "eth_sendRawTransaction", []interface{}{"0x01"}, &first); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FailNextHttp(http.StatusServiceUnavailable)
+	var hash string
+	if err := rpc.CallResult(term, client, server.Endpoint(), "eth_sendRawTransaction", []interface{}{"0x01"}, &hash); err != nil {
+		t.Fatal(err)
+	}
+	if hash != first {
+		t.Errorf("hash %s, want %s", hash, first)
+	}
+	if len(server.SentTransactions()) != 1 {
+		t.Errorf("sent %d transactions, want 1", len(server.SentTransactions()))
+	}
+}
+
+func TestSendRawTransactionGivesUp(t *testing.T) {
+	defer noWaits()()
+	var sends int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "eth_sendRawTransaction") {
+			sends++
+		}
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+
+	var hash string
+	err := rpc.CallResult(term, client, rpc.NewEndpoint(server.URL), "eth_sendRawTransaction", []interface{}{"0x01"}, &hash)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if sends != httpclient.DefaultRetryMax {
+		t.Errorf("sent %d times, want %d", sends, httpclient.DefaultRetryMax)
+	}
+}
@@ -2,28 +2,98 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/metrics"
 	"github.com/jaanek/jeth/ui"
 )
 
 type rpcEndpoint struct {
-	url string
+	url         string
+	transport   *http.Transport
+	timeout     time.Duration
+	gzipMinSize int
+	*endpointAuth
 }
 
 func (e *rpcEndpoint) Url() string {
 	return e.url
 }
 
+func (e *rpcEndpoint) PrepareHttp(req *httpclient.Request) {
+	req.Transport = e.transport
+	req.Timeout = e.timeout
+	req.GzipMinSize = e.gzipMinSize
+}
+
+func (e *rpcEndpoint) Close() error {
+	e.transport.CloseIdleConnections()
+	return nil
+}
+
 type Endpoint interface {
 	Url() string
 }
 
-func NewEndpoint(url string) Endpoint {
-	return &rpcEndpoint{url: url}
+// Transport is implemented by endpoints which carry json-rpc messages over their own
+// connection instead of http requests.
+type Transport interface {
+	RoundTrip(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+// Router is implemented by endpoints which spread requests over other endpoints. It calls
+// send with the chosen endpoints until one of them succeeds. The payload sent can differ
+// from the one routed, e.g. with parts of a batch answered locally.
+type Router interface {
+	Route(ctx context.Context, ui ui.Screen, payload []byte, send func(endpoint Endpoint, payload []byte) ([]byte, error)) ([]byte, error)
+}
+
+// HttpEndpoint is implemented by http endpoints with their own connection settings, e.g.
+// a pool of connections that calls with different clients reuse. PrepareHttp applies them
+// to a request.
+type HttpEndpoint interface {
+	PrepareHttp(req *httpclient.Request)
+}
+
+// NewEndpoint returns an endpoint for a http(s), ws(s) or ipc url. A comma separated list
+// of urls returns a failover endpoint. Basic auth credentials can be given in the url,
+// other credentials with options.
+func NewEndpoint(url string, opts ...EndpointOption) Endpoint {
+	if strings.Contains(url, ",") {
+		return NewFailoverEndpoint(strings.Split(url, ","), false, opts...)
+	}
+	if IsWebsocketUrl(url) {
+		return NewWebsocketEndpoint(url, opts...)
+	}
+	if IsIpcPath(url) {
+		return NewIpcEndpoint(url, opts...)
+	}
+	o := newEndpointOptions(opts)
+	if o.transport == nil {
+		o.transport, _ = httpclient.NewTransport(httpclient.TransportConfig{})
+	}
+	return &rpcEndpoint{
+		url:          url,
+		transport:    o.transport,
+		timeout:      o.timeout,
+		gzipMinSize:  o.gzipMinSize,
+		endpointAuth: &o.auth,
+	}
+}
+
+var lastId uint64
+
+// nextIds reserves n consecutive request ids and returns the first one
+func nextIds(n int) uint {
+	return uint(atomic.AddUint64(&lastId, uint64(n))) - uint(n) + 1
 }
 
 type RpcRequest struct {
@@ -32,6 +102,23 @@ type RpcRequest struct {
 	Method  string        `json:"method"`
 	Params  []interface{} `json:"params"`
 }
+
+// rawRequest and rawResponse keep params and results undecoded, for code that passes
+// messages on
+type rawRequest struct {
+	Id      json.RawMessage `json:"id,omitempty"`
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rawResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RpcError       `json:"error,omitempty"`
+}
+
 type RpcResponse interface {
 	Error() *RpcError
 }
@@ -46,18 +133,40 @@ func (r *RpcResultStr) Error() *RpcError {
 	return r.Err
 }
 
-type RpcError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// RpcResultRaw keeps the result undecoded, so it can hold objects, arrays or null
+type RpcResultRaw struct {
+	Id      uint            `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Err     *RpcError       `json:"error"`
+}
+
+func (r *RpcResultRaw) Error() *RpcError {
+	return r.Err
 }
 
-func (e *RpcError) Error() string {
-	return fmt.Sprintf("code: %d, message: %s", e.Code, e.Message)
+func (r *RpcResultRaw) IsNull() bool {
+	return len(r.Result) == 0 || string(r.Result) == "null"
 }
 
+// Decode unmarshals the result into v. A null result returns ErrNullResult.
+func (r *RpcResultRaw) Decode(v interface{}) error {
+	if r.IsNull() {
+		return ErrNullResult
+	}
+	return json.Unmarshal(r.Result, v)
+}
+
+var ErrNullResult = errors.New("null result")
+
 func Call(ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, method string, params []interface{}, resp RpcResponse) error {
+	return CallContext(context.Background(), ui, client, endpoint, method, params, resp)
+}
+
+// CallContext is Call that gives up when ctx is done, also while waiting to retry
+func CallContext(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, method string, params []interface{}, resp RpcResponse) error {
 	payload, err := json.Marshal(&RpcRequest{
-		Id:      1,
+		Id:      nextIds(1),
 		Version: "2.0",
 		Method:  method,
 		Params:  params,
@@ -66,21 +175,174 @@ func Call(ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, method
 		return err
 	}
 	ui.Log(string(payload))
-	res, err := client.Post(endpoint.Url(), "application/json", bytes.NewReader(payload))
+	start := time.Now()
+	body, err := roundTrip(ctx, ui, client, endpoint, payload)
+	if err == nil {
+		ui.Log(string(body))
+		if err = json.Unmarshal(body, &resp); err == nil && resp.Error() != nil {
+			err = resp.Error()
+		}
+	}
+	metrics.RpcCall(method, time.Since(start), errorCode(err), len(payload), len(body))
 	if err != nil {
+		return withMethod(err, method)
+	}
+	return nil
+}
+
+// CallResult calls the method and decodes its result into the value pointed to by result.
+// A null result returns ErrNullResult.
+func CallResult(ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, method string, params []interface{}, result interface{}) error {
+	return CallResultContext(context.Background(), ui, client, endpoint, method, params, result)
+}
+
+func CallResultContext(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, method string, params []interface{}, result interface{}) error {
+	resp := RpcResultRaw{}
+	if err := CallContext(ctx, ui, client, endpoint, method, params, &resp); err != nil {
 		return err
 	}
+	return resp.Decode(result)
+}
+
+// Forward sends a raw single or batch json-rpc message, as received by a proxy, and returns
+// the raw response
+func Forward(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, payload []byte) ([]byte, error) {
+	method := "batch"
+	var reqs []rawRequest
+	if unmarshalMessages(payload, &reqs) == nil && len(reqs) == 1 && bytes.TrimSpace(payload)[0] != '[' {
+		method = reqs[0].Method
+	}
+	ui.Log(string(payload))
+	sent, clientIds, err := withInternalIds(payload)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	body, err := roundTrip(ctx, ui, client, endpoint, sent)
+	metrics.RpcCall(method, time.Since(start), errorCode(err), len(payload), len(body))
+	if err != nil {
+		return nil, err
+	}
+	body, err = withClientIds(body, clientIds)
+	if err != nil {
+		return nil, err
+	}
+	ui.Log(string(body))
+	return body, nil
+}
+
+// withInternalIds gives the requests in payload ids of this process, so the ids of clients
+// sharing a connection cannot collide and any json value works as an id. It returns the
+// ids of the client by internal id. A payload that is not json-rpc is returned as is.
+func withInternalIds(payload []byte) ([]byte, map[string]json.RawMessage, error) {
+	var reqs []rawRequest
+	if unmarshalMessages(payload, &reqs) != nil || len(reqs) == 0 {
+		return payload, nil, nil
+	}
+	clientIds := make(map[string]json.RawMessage, len(reqs))
+	for i := range reqs {
+		// notifications have no id and get no answer
+		if len(reqs[i].Id) == 0 {
+			continue
+		}
+		id, err := json.Marshal(nextIds(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		clientIds[string(id)] = reqs[i].Id
+		reqs[i].Id = id
+	}
+	if isBatch(payload) {
+		sent, err := json.Marshal(reqs)
+		return sent, clientIds, err
+	}
+	sent, err := json.Marshal(reqs[0])
+	return sent, clientIds, err
+}
+
+// withClientIds puts the ids of the client back into the responses of withInternalIds
+func withClientIds(body []byte, clientIds map[string]json.RawMessage) ([]byte, error) {
+	if len(clientIds) == 0 {
+		return body, nil
+	}
+	var resps []rawResponse
+	if unmarshalMessages(body, &resps) != nil {
+		return body, nil
+	}
+	for i := range resps {
+		if id, ok := clientIds[string(resps[i].Id)]; ok {
+			resps[i].Id = id
+		}
+	}
+	if isBatch(body) {
+		return json.Marshal(resps)
+	}
+	if len(resps) == 0 {
+		return body, nil
+	}
+	return json.Marshal(resps[0])
+}
+
+func roundTrip(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, payload []byte) ([]byte, error) {
+	if r, ok := endpoint.(Router); ok {
+		return r.Route(ctx, ui, payload, func(endpoint Endpoint, payload []byte) ([]byte, error) {
+			return roundTrip(ctx, ui, client, endpoint, payload)
+		})
+	}
+	return sendWithRetries(ctx, ui, client, endpoint, payload)
+}
+
+// send carries payload to a single endpoint
+func send(ctx context.Context, ui ui.Screen, client httpclient.HttpClient, endpoint Endpoint, payload []byte) ([]byte, error) {
+	if t, ok := endpoint.(Transport); ok {
+		return t.RoundTrip(ctx, payload)
+	}
+	req, err := httpclient.NewRequestWithContext(ctx, "POST", endpoint.Url(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// providers count each call of a batch against the rate limit
+	if ids, err := messageIds(payload); err == nil {
+		req.Cost = len(ids)
+	}
+	// a call that changes state might have been processed before the attempt failed,
+	// sendWithRetries decides whether it is sent again
+	if !isReadOnly(payload) {
+		req.RetryCheck = httpclient.NoRetries()
+	}
+	if h, ok := endpoint.(HttpEndpoint); ok {
+		h.PrepareHttp(req)
+	}
+	if a, ok := endpoint.(Authorizer); ok {
+		req.Prepare = func(r *http.Request) error {
+			return a.Authorize(r.Header)
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		var statusErr *httpclient.StatusError
+		if errors.As(err, &statusErr) {
+			return nil, newHttpError(statusErr.StatusCode, statusErr.Body)
+		}
+		return nil, err
+	}
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ui.Log(string(body))
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return err
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, newHttpError(res.StatusCode, body)
 	}
-	if resp.Error() != nil {
-		return resp.Error()
+	return body, nil
+}
+
+// unmarshalMessages decodes a single or batch json-rpc message into a slice
+func unmarshalMessages(msg []byte, v interface{}) error {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) > 0 && msg[0] == '[' {
+		return json.Unmarshal(msg, v)
 	}
-	return nil
+	return json.Unmarshal(append(append([]byte{'['}, msg...), ']'), v)
 }
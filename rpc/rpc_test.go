@@ -0,0 +1,94 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jaanek/jeth/httpclient"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+	"github.com/ledgerwatch/erigon/common"
+)
+
+func TestForwardKeepsClientIds(t *testing.T) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	term := rpctest.NewScreen("")
+	client := httpclient.NewDefault(term)
+
+	tests := []struct {
+		name    string
+		payload string
+		ids     []string
+	}{
+		{"string id", `{"jsonrpc":"2.0","id":"abc","method":"eth_chainId"}`, []string{`"abc"`}},
+		{"null id", `{"jsonrpc":"2.0","id":null,"method":"eth_chainId"}`, []string{`null`}},
+		{"duplicate ids in batch", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}]`, []string{`1`, `1`}},
+		{"notification in batch", `[{"jsonrpc":"2.0","method":"eth_chainId"},{"jsonrpc":"2.0","id":{"a":1},"method":"eth_blockNumber"}]`, []string{`{"a":1}`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := rpc.Forward(context.Background(), term, client, server.Endpoint(), []byte(test.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resps []struct {
+				Id     json.RawMessage `json:"id"`
+				Result string          `json:"result"`
+			}
+			if body[0] != '[' {
+				body = append(append([]byte{'['}, body...), ']')
+			}
+			if err := json.Unmarshal(body, &resps); err != nil {
+				t.Fatalf("%v: %s", err, body)
+			}
+			if len(resps) != len(test.ids) {
+				t.Fatalf("got %d responses, want %d: %s", len(resps), len(test.ids), body)
+			}
+			for i, resp := range resps {
+				if string(resp.Id) != test.ids[i] {
+					t.Errorf("response %d has id %s, want %s", i, resp.Id, test.ids[i])
+				}
+				if resp.Result == "" {
+					t.Errorf("response %d has no result", i)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetBalance makes 1,000 eth_getBalance calls per op, over the pooled connections
+// of an endpoint and over a new connection for every call
+func BenchmarkGetBalance(b *testing.B) {
+	server := rpctest.NewServer()
+	defer server.Close()
+	addr := common.HexToAddress("0x01")
+
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "new connection per call"
+		}
+		b.Run(name, func(b *testing.B) {
+			transport, err := httpclient.NewTransport(httpclient.TransportConfig{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			transport.DisableKeepAlives = !pooled
+			defer transport.CloseIdleConnections()
+			endpoint := rpc.NewEndpoint(server.URL, rpc.WithTransport(transport))
+			term := rpctest.NewScreen("")
+			client := httpclient.NewDefault(term)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 1000; j++ {
+					var balance string
+					if err := rpc.CallResult(term, client, endpoint, "eth_getBalance", []interface{}{addr, "latest"}, &balance); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package rpc_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaanek/jeth/rpc"
+	"github.com/jaanek/jeth/rpctest"
+)
+
+// wsPeer is the server side of a websocket connection, written by hand so the tests check
+// the framing of the client against the rfc rather than against another implementation
+type wsPeer struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type testRequest struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// serveWebsocket runs handle for every websocket connection and returns the ws:// url
+func serveWebsocket(t *testing.T, handle func(p *wsPeer)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+		rw.Flush()
+		handle(&wsPeer{t: t, conn: conn, r: rw.Reader})
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// readFrame reads a frame and fails the test when the client did not mask it
+func (p *wsPeer) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(p.r, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	if head[1]&0x80 == 0 {
+		p.t.Errorf("client frame with opcode %d is not masked", opcode)
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(p.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(p.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(p.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(p.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame writes an unmasked frame, as servers do
+func (p *wsPeer) writeFrame(fin bool, opcode byte, payload []byte) {
+	head := []byte{opcode, 0}
+	if fin {
+		head[0] |= 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		head[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(len(payload)))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(len(payload)))
+	}
+	if _, err := p.conn.Write(append(head, payload...)); err != nil {
+		p.t.Error(err)
+	}
+}
+
+// readRequest reads the next text message as a json-rpc request
+func (p *wsPeer) readRequest() (testRequest, error) {
+	var req testRequest
+	fin, opcode, payload, err := p.readFrame()
+	if err != nil {
+		return req, err
+	}
+	if opcode != 0x1 || !fin {
+		return req, fmt.Errorf("got opcode %d fin %v, want a single text frame", opcode, fin)
+	}
+	return req, json.Unmarshal(payload, &req)
+}
+
+func (p *wsPeer) reply(req testRequest, result interface{}) {
+	msg, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result})
+	p.writeFrame(true, 0x1, msg)
+}
+
+func closeEndpoint(endpoint rpc.Endpoint) {
+	endpoint.(io.Closer).Close()
+}
+
+func TestWebsocketFraming(t *testing.T) {
+	// payload lengths that need the 7 bit, 16 bit and 64 bit length encodings
+	sizes := []int{10, 200, 70000}
+	url := serveWebsocket(t, func(p *wsPeer) {
+		for {
+			req, err := p.readRequest()
+			if err != nil {
+				return
+			}
+			var size int
+			json.Unmarshal(req.Params[0], &size)
+			msg, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": strings.Repeat("a", size)})
+			if size == sizes[len(sizes)-1] {
+				// the largest answer comes in fragments
+				p.writeFrame(false, 0x1, msg[:100])
+				p.writeFrame(false, 0x0, msg[100:200])
+				p.writeFrame(true, 0x0, msg[200:])
+				continue
+			}
+			p.writeFrame(true, 0x1, msg)
+		}
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	for _, size := range sizes {
+		// the request grows with the size too, so the client frames use every encoding
+		var result string
+		if err := rpc.CallResult(term, nil, endpoint, "test_echo", []interface{}{size, strings.Repeat("b", size)}, &result); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if len(result) != size {
+			t.Errorf("got %d bytes, want %d", len(result), size)
+		}
+	}
+}
+
+func TestWebsocketPingPong(t *testing.T) {
+	pong := make(chan []byte, 1)
+	url := serveWebsocket(t, func(p *wsPeer) {
+		req, err := p.readRequest()
+		if err != nil {
+			return
+		}
+		p.writeFrame(true, 0x9, []byte("are you there"))
+		_, opcode, payload, err := p.readFrame()
+		if err != nil || opcode != 0xa {
+			t.Errorf("got opcode %d, %v, want a pong", opcode, err)
+			return
+		}
+		pong <- payload
+		// a pong nobody asked for is ignored
+		p.writeFrame(true, 0xa, nil)
+		p.reply(req, "0x1")
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	var result string
+	if err := rpc.CallResult(term, nil, endpoint, "eth_chainId", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "0x1" {
+		t.Errorf("got %s, want 0x1", result)
+	}
+	if got := string(<-pong); got != "are you there" {
+		t.Errorf("pong with %q, want the ping payload", got)
+	}
+}
+
+func TestWebsocketClose(t *testing.T) {
+	closeReply := make(chan byte, 1)
+	connections := 0
+	url := serveWebsocket(t, func(p *wsPeer) {
+		connections++
+		req, err := p.readRequest()
+		if err != nil {
+			return
+		}
+		if connections == 1 {
+			// a closing server answers nothing more
+			p.writeFrame(true, 0x8, []byte{0x03, 0xe8})
+			_, opcode, _, _ := p.readFrame()
+			closeReply <- opcode
+			return
+		}
+		p.reply(req, "0x2")
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	var result string
+	if err := rpc.CallResult(term, nil, endpoint, "eth_chainId", nil, &result); err == nil {
+		t.Fatal("expected the call to fail when the server closes")
+	}
+	if opcode := <-closeReply; opcode != 0x8 {
+		t.Errorf("client answered close with opcode %d, want a close frame", opcode)
+	}
+	// the next call dials again
+	if err := rpc.CallResult(term, nil, endpoint, "eth_chainId", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != "0x2" {
+		t.Errorf("got %s, want 0x2", result)
+	}
+}
+
+func TestSubscriptionSlowReceiverDoesNotBlockCalls(t *testing.T) {
+	defer func(n int) { rpc.SubscriptionBuffer = n }(rpc.SubscriptionBuffer)
+	rpc.SubscriptionBuffer = 10
+	url := serveWebsocket(t, func(p *wsPeer) {
+		for {
+			req, err := p.readRequest()
+			if err != nil {
+				return
+			}
+			switch req.Method {
+			case "eth_subscribe":
+				p.reply(req, "0xsub")
+				for i := 0; i < 100; i++ {
+					p.writeFrame(true, 0x1, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xsub","result":%d}}`, i)))
+				}
+			default:
+				p.reply(req, "0x1")
+			}
+		}
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	// nobody reads ch
+	ch := make(chan json.RawMessage)
+	sub, err := rpc.Subscribe(context.Background(), term, endpoint, ch, []interface{}{rpc.NewHeads})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var result string
+	if err := rpc.CallResultContext(ctx, term, nil, endpoint, "eth_chainId", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if sub.Dropped() == 0 {
+		t.Error("expected notifications to be dropped")
+	}
+	if first := <-ch; string(first) != "0" {
+		t.Errorf("first notification %s, want 0", first)
+	}
+}
+
+func TestSubscribeCancelledBeforeAck(t *testing.T) {
+	subscribed := make(chan struct{})
+	ack := make(chan struct{})
+	unsubscribed := make(chan string, 1)
+	url := serveWebsocket(t, func(p *wsPeer) {
+		for {
+			req, err := p.readRequest()
+			if err != nil {
+				return
+			}
+			switch req.Method {
+			case "eth_subscribe":
+				close(subscribed)
+				<-ack
+				p.reply(req, "0xlate")
+			case "eth_unsubscribe":
+				var id string
+				json.Unmarshal(req.Params[0], &id)
+				unsubscribed <- id
+				p.reply(req, true)
+			}
+		}
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-subscribed
+		cancel()
+	}()
+	_, err := rpc.Subscribe(ctx, term, endpoint, make(chan json.RawMessage), []interface{}{rpc.NewHeads})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	close(ack)
+	select {
+	case id := <-unsubscribed:
+		if id != "0xlate" {
+			t.Errorf("unsubscribed %s, want 0xlate", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the late subscription was not unsubscribed")
+	}
+}
+
+func TestNullIdErrorFailsTheWaitingCall(t *testing.T) {
+	url := serveWebsocket(t, func(p *wsPeer) {
+		if _, err := p.readRequest(); err != nil {
+			return
+		}
+		p.writeFrame(true, 0x1, []byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}}`))
+		p.readFrame()
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	done := make(chan error, 1)
+	go func() {
+		var result string
+		done <- rpc.CallResult(term, nil, endpoint, "eth_chainId", nil, &result)
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "invalid request") {
+			t.Errorf("got %v, want the invalid request error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call waits forever for an answer with a null id")
+	}
+}
+
+func TestNullIdErrorFailsAllCallsWhenAmbiguous(t *testing.T) {
+	url := serveWebsocket(t, func(p *wsPeer) {
+		for i := 0; i < 2; i++ {
+			if _, err := p.readRequest(); err != nil {
+				return
+			}
+		}
+		p.writeFrame(true, 0x1, []byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`))
+		p.readFrame()
+	})
+	endpoint := rpc.NewEndpoint(url)
+	defer closeEndpoint(endpoint)
+	term := rpctest.NewScreen("")
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var result string
+			done <- rpc.CallResult(term, nil, endpoint, "eth_chainId", nil, &result)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if !errors.Is(err, rpc.ErrInvalidResponse) || !strings.Contains(err.Error(), "parse error") {
+				t.Errorf("got %v, want an invalid response with the parse error", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a call waits forever for an answer with a null id")
+		}
+	}
+}
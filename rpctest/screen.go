@@ -0,0 +1,104 @@
+package rpctest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jaanek/jeth/ui"
+)
+
+// Screen is an in-memory ui.Screen that captures everything written to it
+type Screen struct {
+	Password []byte
+	// Format is the format of Render, json when empty
+	Format ui.Format
+
+	mu     sync.Mutex
+	output strings.Builder
+	prints []string
+	logs   []string
+	errors []string
+}
+
+var _ ui.Screen = (*Screen)(nil)
+
+// NewScreen returns a screen that answers ReadPassword with password
+func NewScreen(password string) *Screen {
+	return &Screen{Password: []byte(password)}
+}
+
+func (s *Screen) ReadPassword() ([]byte, error) {
+	return s.Password, nil
+}
+
+func (s *Screen) Print(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prints = append(s.prints, msg)
+}
+
+func (s *Screen) Output(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output.WriteString(msg)
+}
+
+func (s *Screen) Render(out interface{}) error {
+	format := s.Format
+	if format == "" {
+		format = ui.FormatJson
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ui.Render(&s.output, format, out)
+}
+
+func (s *Screen) Log(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, fmt.Sprintf("%v", msg))
+}
+
+func (s *Screen) Logf(msg string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, fmt.Sprintf(msg, args...))
+}
+
+func (s *Screen) Error(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, fmt.Sprintf("%v", msg))
+}
+
+func (s *Screen) Errorf(msg string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, fmt.Sprintf(msg, args...))
+}
+
+// Stdout returns everything written with Output, what a command prints to stdout
+func (s *Screen) Stdout() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output.String()
+}
+
+func (s *Screen) Prints() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.prints...)
+}
+
+func (s *Screen) Logs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.logs...)
+}
+
+func (s *Screen) Errors() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.errors...)
+}
@@ -0,0 +1,453 @@
+package rpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/holiman/uint256"
+	"github.com/jaanek/jeth/rpc"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/crypto"
+)
+
+// Server is an in-process json-rpc server that answers the eth_* methods used by jeth from
+// programmable state. All setters can be called while requests are served.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	chainId       uint64
+	blockNumber   uint64
+	gasPrice      *uint256.Int
+	tip           *uint256.Int
+	gasEstimate   uint64
+	balances      map[common.Address]*uint256.Int
+	nonces        map[common.Address]uint64
+	pendingNonces map[common.Address]uint64
+	code          map[common.Address][]byte
+	callResults   map[string]callResult
+	receipts      map[common.Hash]*receipt
+	autoMine      int
+	failures      map[string][]*rpc.RpcError
+	httpFailures  []int
+	requests      []string
+	sent          [][]byte
+}
+
+type callResult struct {
+	result []byte
+	revert *rpc.RpcError
+}
+
+type receipt struct {
+	body  json.RawMessage
+	polls int
+}
+
+type request struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpc.RpcError   `json:"error,omitempty"`
+}
+
+// NewServer starts a server for chain id 1337 at block 1 with a gas price and tip of 1 gwei
+// and a gas estimate of 21000. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		chainId:       1337,
+		blockNumber:   1,
+		gasPrice:      uint256.NewInt(1000000000),
+		tip:           uint256.NewInt(1000000000),
+		gasEstimate:   21000,
+		balances:      map[common.Address]*uint256.Int{},
+		nonces:        map[common.Address]uint64{},
+		pendingNonces: map[common.Address]uint64{},
+		code:          map[common.Address][]byte{},
+		callResults:   map[string]callResult{},
+		receipts:      map[common.Hash]*receipt{},
+		autoMine:      -1,
+		failures:      map[string][]*rpc.RpcError{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns an http endpoint for the server
+func (s *Server) Endpoint() rpc.Endpoint {
+	return rpc.NewEndpoint(s.URL)
+}
+
+func (s *Server) SetChainId(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chainId = id
+}
+
+func (s *Server) SetBlockNumber(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockNumber = n
+}
+
+func (s *Server) SetGasPrice(wei *uint256.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gasPrice = wei
+}
+
+// SetMaxPriorityFee sets the eth_maxPriorityFeePerGas answer. With nil the method does not
+// exist, as on nodes without london support.
+func (s *Server) SetMaxPriorityFee(wei *uint256.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tip = wei
+}
+
+func (s *Server) SetGasEstimate(gas uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gasEstimate = gas
+}
+
+func (s *Server) SetBalance(addr common.Address, wei *uint256.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances[addr] = wei
+}
+
+// SetNonce sets the transaction count of addr for the latest and the pending block
+func (s *Server) SetNonce(addr common.Address, latest, pending uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonces[addr] = latest
+	s.pendingNonces[addr] = pending
+}
+
+func (s *Server) SetCode(addr common.Address, code []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.code[addr] = code
+}
+
+// SetCallResult sets what eth_call to the address with the given input returns. With nil
+// input it is returned for any input without an exact match.
+func (s *Server) SetCallResult(to common.Address, input []byte, result []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callResults[callKey(to, input)] = callResult{result: result}
+}
+
+// SetCallRevert makes eth_call to the address with the given input revert with reason
+func (s *Server) SetCallRevert(to common.Address, input []byte, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callResults[callKey(to, input)] = callResult{revert: RevertError(reason)}
+}
+
+// AddReceipt makes eth_getTransactionReceipt return receipt for hash after it was asked
+// for afterPolls times. The block number advances when it appears.
+func (s *Server) AddReceipt(hash common.Hash, r interface{}, afterPolls int) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receipts[hash] = &receipt{body: body, polls: afterPolls}
+	return nil
+}
+
+// AutoMine adds a successful receipt for every sent raw transaction, which appears after
+// afterPolls receipt requests. A negative value turns it off.
+func (s *Server) AutoMine(afterPolls int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoMine = afterPolls
+}
+
+// FailNext makes the next call of method fail with err. Calls queue up and are used in order.
+func (s *Server) FailNext(method string, err *rpc.RpcError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], err)
+}
+
+// FailNextHttp answers the next http request with status instead of a json-rpc response.
+// Calls queue up and are used in order.
+func (s *Server) FailNextHttp(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.httpFailures = append(s.httpFailures, status)
+}
+
+// Requests returns the methods called so far, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// SentTransactions returns the raw transactions received with eth_sendRawTransaction
+func (s *Server) SentTransactions() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte{}, s.sent...)
+}
+
+// RevertError is the error a node returns for a call that reverted with reason
+func RevertError(reason string) *rpc.RpcError {
+	data, _ := json.Marshal(hexutil.Encode(encodeRevertReason(reason)))
+	return &rpc.RpcError{Code: rpc.CodeExecutionReverted, Message: "execution reverted: " + reason, Data: data}
+}
+
+// encodeRevertReason abi encodes Error(string)
+func encodeRevertReason(reason string) []byte {
+	word := func(n int) []byte {
+		return common.LeftPadBytes(uint256.NewInt(uint64(n)).Bytes(), 32)
+	}
+	data := []byte{0x08, 0xc3, 0x79, 0xa0}
+	data = append(data, word(32)...)
+	data = append(data, word(len(reason))...)
+	data = append(data, common.RightPadBytes([]byte(reason), (len(reason)+31)/32*32)...)
+	return data
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	if len(s.httpFailures) > 0 {
+		status := s.httpFailures[0]
+		s.httpFailures = s.httpFailures[1:]
+		s.mu.Unlock()
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	s.mu.Unlock()
+
+	body = bytes.TrimSpace(body)
+	var out interface{}
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []request
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resps := make([]response, 0, len(reqs))
+		for _, req := range reqs {
+			resp := s.handle(req)
+			// notifications get no answer
+			if len(req.Id) > 0 {
+				resps = append(resps, resp)
+			}
+		}
+		if len(resps) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		out = resps
+	} else {
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out = s.handle(req)
+		if len(req.Id) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) handle(req request) response {
+	resp := response{Version: "2.0", Id: req.Id}
+	result, err := s.call(req.Method, req.Params)
+	if err != nil {
+		resp.Error = err
+	} else if result == nil {
+		resp.Result = json.RawMessage("null")
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
+
+func (s *Server) call(method string, params []json.RawMessage) (interface{}, *rpc.RpcError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, method)
+	if queue := s.failures[method]; len(queue) > 0 {
+		s.failures[method] = queue[1:]
+		return nil, queue[0]
+	}
+
+	switch method {
+	case "eth_chainId":
+		return hexutil.EncodeUint64(s.chainId), nil
+	case "eth_blockNumber":
+		return hexutil.EncodeUint64(s.blockNumber), nil
+	case "eth_gasPrice":
+		return s.gasPrice.Hex(), nil
+	case "eth_maxPriorityFeePerGas":
+		if s.tip == nil {
+			return nil, methodNotFound(method)
+		}
+		return s.tip.Hex(), nil
+	case "eth_estimateGas":
+		return hexutil.EncodeUint64(s.gasEstimate), nil
+	case "eth_getBalance":
+		addr, err := addressParam(params)
+		if err != nil {
+			return nil, err
+		}
+		if balance, ok := s.balances[addr]; ok {
+			return balance.Hex(), nil
+		}
+		return "0x0", nil
+	case "eth_getTransactionCount":
+		addr, err := addressParam(params)
+		if err != nil {
+			return nil, err
+		}
+		var tag string
+		if len(params) > 1 {
+			json.Unmarshal(params[1], &tag)
+		}
+		if tag == "pending" {
+			return hexutil.EncodeUint64(s.pendingNonces[addr]), nil
+		}
+		return hexutil.EncodeUint64(s.nonces[addr]), nil
+	case "eth_getCode":
+		addr, err := addressParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return hexutil.Encode(s.code[addr]), nil
+	case "eth_call":
+		return s.ethCall(params)
+	case "eth_sendRawTransaction":
+		return s.sendRawTransaction(params)
+	case "eth_getTransactionByHash":
+		var hash common.Hash
+		if len(params) == 0 || json.Unmarshal(params[0], &hash) != nil {
+			return nil, invalidParams("expected a transaction hash")
+		}
+		for _, raw := range s.sent {
+			if crypto.Keccak256Hash(raw) == hash {
+				return map[string]interface{}{"hash": hash.Hex()}, nil
+			}
+		}
+		return nil, nil
+	case "eth_getTransactionReceipt":
+		var hash common.Hash
+		if len(params) == 0 || json.Unmarshal(params[0], &hash) != nil {
+			return nil, invalidParams("expected a transaction hash")
+		}
+		r, ok := s.receipts[hash]
+		if !ok {
+			return nil, nil
+		}
+		if r.polls > 0 {
+			r.polls--
+			return nil, nil
+		}
+		if r.polls == 0 {
+			// the block with the transaction is mined when it is first seen
+			r.polls--
+			s.blockNumber++
+		}
+		return r.body, nil
+	}
+	return nil, methodNotFound(method)
+}
+
+func (s *Server) ethCall(params []json.RawMessage) (interface{}, *rpc.RpcError) {
+	var msg struct {
+		To    common.Address `json:"to"`
+		Data  hexutil.Bytes  `json:"data"`
+		Input hexutil.Bytes  `json:"input"`
+	}
+	if len(params) == 0 || json.Unmarshal(params[0], &msg) != nil {
+		return nil, invalidParams("expected a call object")
+	}
+	input := msg.Input
+	if len(input) == 0 {
+		input = msg.Data
+	}
+	res, ok := s.callResults[callKey(msg.To, input)]
+	if !ok {
+		res, ok = s.callResults[callKey(msg.To, nil)]
+	}
+	if !ok {
+		return "0x", nil
+	}
+	if res.revert != nil {
+		return nil, res.revert
+	}
+	return hexutil.Encode(res.result), nil
+}
+
+func (s *Server) sendRawTransaction(params []json.RawMessage) (interface{}, *rpc.RpcError) {
+	var raw hexutil.Bytes
+	if len(params) == 0 || json.Unmarshal(params[0], &raw) != nil {
+		return nil, invalidParams("expected a raw transaction")
+	}
+	s.sent = append(s.sent, raw)
+	hash := crypto.Keccak256Hash(raw)
+	if s.autoMine >= 0 {
+		body, _ := json.Marshal(map[string]interface{}{
+			"transactionHash":   hash.Hex(),
+			"blockNumber":       hexutil.EncodeUint64(s.blockNumber + 1),
+			"status":            "0x1",
+			"gasUsed":           hexutil.EncodeUint64(s.gasEstimate),
+			"cumulativeGasUsed": hexutil.EncodeUint64(s.gasEstimate),
+			"effectiveGasPrice": s.gasPrice.Hex(),
+			"logs":              []interface{}{},
+		})
+		s.receipts[hash] = &receipt{body: body, polls: s.autoMine}
+	}
+	return hash.Hex(), nil
+}
+
+func addressParam(params []json.RawMessage) (common.Address, *rpc.RpcError) {
+	var addr common.Address
+	if len(params) == 0 || json.Unmarshal(params[0], &addr) != nil {
+		return addr, invalidParams("expected an address")
+	}
+	return addr, nil
+}
+
+func callKey(to common.Address, input []byte) string {
+	if input == nil {
+		return strings.ToLower(to.Hex())
+	}
+	return strings.ToLower(to.Hex()) + hexutil.Encode(input)
+}
+
+func methodNotFound(method string) *rpc.RpcError {
+	return &rpc.RpcError{Code: rpc.CodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+}
+
+func invalidParams(msg string) *rpc.RpcError {
+	return &rpc.RpcError{Code: -32602, Message: "invalid params: " + msg}
+}
@@ -0,0 +1,326 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is how Screen.Render writes the output of a command
+type Format string
+
+const (
+	FormatText  = Format("text")
+	FormatJson  = Format("json")
+	FormatTable = Format("table")
+	FormatCsv   = Format("csv")
+	FormatYaml  = Format("yaml")
+)
+
+var Formats = []Format{FormatJson, FormatText, FormatTable, FormatCsv, FormatYaml}
+
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(name) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, expected one of json, text, table, csv or yaml", name)
+}
+
+// Texter is implemented by outputs that have their own text format
+type Texter interface {
+	Text() string
+}
+
+// WithText returns out with text as its text format, the other formats render out
+func WithText(out interface{}, text string) interface{} {
+	return textOutput{out: out, text: text}
+}
+
+type textOutput struct {
+	out  interface{}
+	text string
+}
+
+func (o textOutput) Text() string {
+	return o.text
+}
+
+func (o textOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.out)
+}
+
+// Render writes out to w in format. Every format is made from the json of out, so the
+// json field names are the schema of all of them. The text format of an object with a
+// single field is its value, else a "name: value" line per field.
+func Render(w io.Writer, format Format, out interface{}) error {
+	if t, ok := out.(Texter); ok && format == FormatText {
+		_, err := fmt.Fprintln(w, strings.TrimSuffix(t.Text(), "\n"))
+		return err
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if format == FormatJson {
+		_, err := fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	v, err := decodeOrdered(b)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatText:
+		return renderText(w, v)
+	case FormatTable:
+		return renderTable(w, v)
+	case FormatCsv:
+		return renderCsv(w, v)
+	case FormatYaml:
+		var buf bytes.Buffer
+		renderYaml(&buf, v, 0)
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// field is a member of a json object, objects are decoded to []field to keep the order
+// of their fields
+type field struct {
+	name  string
+	value interface{}
+}
+
+func decodeOrdered(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		fields := []field{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field{name: name.(string), value: value})
+		}
+		_, err := dec.Token()
+		return fields, err
+	case json.Delim('['):
+		values := []interface{}{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err := dec.Token()
+		return values, err
+	}
+	return token, nil
+}
+
+func renderText(w io.Writer, v interface{}) error {
+	fields, ok := v.([]field)
+	if !ok || len(fields) == 1 {
+		if ok {
+			v = fields[0].value
+		}
+		_, err := fmt.Fprintln(w, scalar(v))
+		return err
+	}
+	for _, f := range fields {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", f.name, scalar(f.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderTable(w io.Writer, v interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows(v) {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func renderCsv(w io.Writer, v interface{}) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows(v)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// rows returns a header with the field names and a row per object, an array of objects
+// has a row for each of them
+func rows(v interface{}) [][]string {
+	objects, ok := v.([]interface{})
+	if !ok {
+		objects = []interface{}{v}
+	}
+	var header []string
+	index := map[string]int{}
+	for _, o := range objects {
+		fields, ok := o.([]field)
+		if !ok {
+			fields = []field{{name: "value", value: o}}
+		}
+		for _, f := range fields {
+			if _, ok := index[f.name]; !ok {
+				index[f.name] = len(header)
+				header = append(header, f.name)
+			}
+		}
+	}
+	result := [][]string{header}
+	for _, o := range objects {
+		fields, ok := o.([]field)
+		if !ok {
+			fields = []field{{name: "value", value: o}}
+		}
+		row := make([]string, len(header))
+		for _, f := range fields {
+			row[index[f.name]] = scalar(f.value)
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// scalar formats a value for text, table and csv, nested objects and arrays as json
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	var buf bytes.Buffer
+	writeJson(&buf, v)
+	return buf.String()
+}
+
+func writeJson(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case []field:
+		buf.WriteByte('{')
+		for i, f := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(f.name)
+			buf.Write(name)
+			buf.WriteByte(':')
+			writeJson(buf, f.value)
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJson(buf, e)
+		}
+		buf.WriteByte(']')
+	default:
+		b, _ := json.Marshal(v)
+		buf.Write(b)
+	}
+}
+
+// renderYaml writes v as a yaml block. Strings are always double quoted, hex values like
+// 0x10 would be read back as numbers otherwise.
+func renderYaml(buf *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch v := v.(type) {
+	case []field:
+		if len(v) == 0 {
+			buf.WriteString(pad + "{}\n")
+			return
+		}
+		for _, f := range v {
+			buf.WriteString(pad + yamlKey(f.name) + ":")
+			writeYamlValue(buf, f.value, indent+1)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(pad + "[]\n")
+			return
+		}
+		for _, e := range v {
+			buf.WriteString(pad + "-")
+			writeYamlValue(buf, e, indent+1)
+		}
+	default:
+		buf.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+// writeYamlValue continues a line that ends with a key or a dash
+func writeYamlValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch e := v.(type) {
+	case []field:
+		if len(e) > 0 {
+			buf.WriteString("\n")
+			renderYaml(buf, e, indent)
+			return
+		}
+		buf.WriteString(" {}\n")
+	case []interface{}:
+		if len(e) > 0 {
+			buf.WriteString("\n")
+			renderYaml(buf, e, indent)
+			return
+		}
+		buf.WriteString(" []\n")
+	default:
+		buf.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+func yamlKey(name string) string {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	}
+	return scalar(v)
+}
@@ -17,14 +17,21 @@ type Screen interface {
 	Logf(msg string, args ...interface{})
 	Error(msg interface{})
 	Errorf(msg string, args ...interface{})
+	// Render writes the output of a command to stdout in the format of the screen
+	Render(out interface{}) error
 }
 
 type term struct {
 	verbose bool
+	format  Format
 }
 
 func NewTerminal(verbose bool) Screen {
-	return &term{verbose: verbose}
+	return NewTerminalWithFormat(verbose, FormatText)
+}
+
+func NewTerminalWithFormat(verbose bool, format Format) Screen {
+	return &term{verbose: verbose, format: format}
 }
 
 func (t *term) ReadPassword() ([]byte, error) {
@@ -40,6 +47,10 @@ func (t *term) Output(msg string) {
 	fmt.Fprint(os.Stdout, msg)
 }
 
+func (t *term) Render(out interface{}) error {
+	return Render(os.Stdout, t.format, out)
+}
+
 func (t *term) Logf(msg string, args ...interface{}) {
 	if t.verbose {
 		fmt.Fprintf(os.Stderr, fmt.Sprintf(msg, args...))